@@ -0,0 +1,260 @@
+package session
+
+import (
+	"io"
+	"log"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//the bolt operations the BoltStore needs, the boltstore package wraps a bbolt
+//database in this, BoltStoreWithDB takes any implementation (or a fake in tests)
+//View and Update run fn in a read or a write transaction, on the bucket the
+//sessions are kept in
+type BoltDB interface {
+	View(fn func(b BoltBucket) error) error
+	Update(fn func(b BoltBucket) error) error
+}
+
+//the sessions bucket within a transaction, keyed by session id, the slices Get
+//and ForEach give are only valid until the transaction ends
+type BoltBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	ForEach(fn func(key, value []byte) error) error
+	//the number of keys in the bucket
+	KeyN() int
+}
+
+//a session store in an embedded bolt database, sessions survive a restart
+//without a separate database server
+//bolt locks its file, so only one process can use the database at a time
+type BoltStore struct {
+	storeConfig
+	sweeper
+	db BoltDB
+}
+
+//ctor for a BoltStore using db, see the boltstore package for opening a bbolt file
+func BoltStoreWithDB(db BoltDB, opts ...Option) *BoltStore {
+	s := &BoltStore{storeConfig: newStoreConfig(opts), sweeper: newSweeper(), db: db}
+	s.taken = s.has
+	go s.Sweep()
+	return s
+}
+
+//stop the sweep loop, and close the database if it's an io.Closer, as the
+//databases the boltstore package opens itself are
+func (s *BoltStore) Close() error {
+	s.sweeper.Close()
+	if c, ok := s.db.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+//whether a session is stored under id
+func (s *BoltStore) has(id string) bool {
+	found := false
+	s.db.View(func(b BoltBucket) error {
+		found = b.Get([]byte(id)) != nil
+		return nil
+	})
+	return found
+}
+
+func (s *BoltStore) Load(req *web.Request) *Session {
+	if sess, ok := s.LoadByID(s.loadID(req)); ok {
+		return s.loaded(req, sess)
+	}
+	return s.fresh(req)
+}
+
+//the live session stored under id, see IDLoader
+func (s *BoltStore) LoadByID(id string) (*Session, bool) {
+	if id == "" {
+		return nil, false
+	}
+
+	var sess *Session
+	err := s.db.View(func(b BoltBucket) error {
+		//the value is only valid inside the transaction, so it's decoded here
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		var err error
+		sess, err = s.codec.Unmarshal(v)
+		return err
+	})
+	if err != nil {
+		log.Printf("session: bolt load of %s failed: %v", id, err)
+		return nil, false
+	}
+	//it may not have been swept yet
+	if sess == nil || s.expired(sess) {
+		return nil, false
+	}
+	return sess, true
+}
+
+//the stored version is checked in the same transaction as the write, see ErrConflict
+func (s *BoltStore) Save(req *web.Request, sess *Session) error {
+	return s.db.Update(func(b BoltBucket) error {
+		var current *Session
+		if v := b.Get([]byte(sess.id)); v != nil {
+			//an undecodable entry is overwritten
+			current, _ = s.codec.Unmarshal(v)
+		}
+		if err := s.nextVersion(current, sess); err != nil {
+			return err
+		}
+		s.stamp(sess)
+		data, err := s.codec.Marshal(sess)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(sess.id), data)
+	})
+}
+
+func (s *BoltStore) Destroy(req *web.Request, sess *Session) {
+	err := s.db.Update(func(b BoltBucket) error {
+		return b.Delete([]byte(sess.id))
+	})
+	if err != nil {
+		log.Printf("session: bolt delete of %s failed: %v", sess.id, err)
+	}
+	s.destroyed(sess)
+}
+
+//call fn with each live session, stopping if it returns false, see Iterable
+//fn is called inside a read transaction, so it mustn't save or destroy sessions
+//in the store
+func (s *BoltStore) ForEach(fn func(id string, sess *Session) bool) {
+	err := s.db.View(func(b BoltBucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			sess, err := s.codec.Unmarshal(v)
+			if err != nil || s.expired(sess) {
+				return nil
+			}
+			if !fn(sess.id, sess) {
+				return errStopIteration
+			}
+			return nil
+		})
+	})
+	if err != nil && err != errStopIteration {
+		log.Printf("session: bolt iteration failed: %v", err)
+	}
+}
+
+//empty the sessions bucket, see AllDestroyer
+func (s *BoltStore) DestroyAll() error {
+	var sessions []*Session
+	err := s.db.Update(func(b BoltBucket) error {
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			keys = append(keys, append([]byte(nil), k...))
+			if s.onDestroy != nil {
+				if sess, err := s.codec.Unmarshal(v); err == nil {
+					sessions = append(sessions, sess)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, sess := range sessions {
+		s.destroyed(sess)
+	}
+	return nil
+}
+
+func (s *BoltStore) Count() int {
+	n := 0
+	s.db.View(func(b BoltBucket) error {
+		n = b.KeyN()
+		return nil
+	})
+	return n
+}
+
+//delete the expired sessions
+//Sweep runs until the store is closed
+func (s *BoltStore) Sweep() {
+	tick, stopTicker := s.sweepTicker()
+	defer stopTicker()
+	for {
+		beg := time.Now()
+
+		l, i, err := s.sweep()
+		if err != nil {
+			log.Printf("session: bolt sweep failed: %v", err)
+		} else if taken := time.Since(beg); s.stats != nil {
+			s.stats(l, i, taken)
+		} else {
+			log.Printf("session bolt store had %d total sessions, but deleted %d sessions. took %v ms",
+				l, i, int64(taken/time.Millisecond))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-tick:
+		}
+	}
+}
+
+//a single sweep pass in one write transaction, returns the number of sessions there
+//were and the number deleted
+//entries that can't be decoded are deleted too
+func (s *BoltStore) sweep() (int, int, error) {
+	var sessions []*Session
+	l, i := 0, 0
+	err := s.db.Update(func(b BoltBucket) error {
+		//bolt doesn't allow deleting from a bucket while iterating it
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			l++
+			sess, err := s.codec.Unmarshal(v)
+			if err != nil {
+				expired = append(expired, append([]byte(nil), k...))
+			} else if s.expired(sess) {
+				expired = append(expired, append([]byte(nil), k...))
+				sessions = append(sessions, sess)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		i = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	//the transaction has committed, so the hooks are free to use the store
+	for _, sess := range sessions {
+		s.destroyed(sess)
+	}
+	return l, i, nil
+}
@@ -0,0 +1,81 @@
+//bbolt databases for the session package's BoltStore, kept in a package of their
+//own so only programs using bolt depend on it
+//	store, err := boltstore.New("sessions.db", session.MaxAge(time.Hour))
+package boltstore
+
+import (
+	"time"
+	"github.com/nstott/session"
+	bolt "go.etcd.io/bbolt"
+)
+
+//the bucket sessions are kept in, keyed by session id
+var bucketName = []byte("sessions")
+
+//ctor, opens (or creates) the bolt database at path, it's closed along with the store
+func New(path string, opts ...session.Option) (*session.BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := wrap(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return session.BoltStoreWithDB(ownedDB{wrapped}, opts...), nil
+}
+
+//ctor for a BoltStore using an already open database, which is left open when the
+//store is closed, the sessions bucket is created if it doesn't exist
+func WithDB(db *bolt.DB, opts ...session.Option) (*session.BoltStore, error) {
+	wrapped, err := wrap(db)
+	if err != nil {
+		return nil, err
+	}
+	return session.BoltStoreWithDB(wrapped, opts...), nil
+}
+
+//session.BoltDB over bbolt
+type boltDB struct {
+	db *bolt.DB
+}
+
+//create the sessions bucket if it's not there
+func wrap(db *bolt.DB) (boltDB, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	return boltDB{db}, err
+}
+
+func (d boltDB) View(fn func(b session.BoltBucket) error) error {
+	return d.db.View(func(tx *bolt.Tx) error {
+		return fn(bucket{tx.Bucket(bucketName)})
+	})
+}
+
+func (d boltDB) Update(fn func(b session.BoltBucket) error) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return fn(bucket{tx.Bucket(bucketName)})
+	})
+}
+
+//a database New opened, the store closes it
+type ownedDB struct {
+	boltDB
+}
+
+func (d ownedDB) Close() error {
+	return d.db.Close()
+}
+
+//session.BoltBucket over a bbolt bucket
+type bucket struct {
+	*bolt.Bucket
+}
+
+func (b bucket) KeyN() int {
+	return b.Stats().KeyN
+}
@@ -0,0 +1,84 @@
+package boltstore
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"github.com/nstott/session"
+)
+
+//a session.Clock set by hand, the store's sweep loop reads it too
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, sess := session.NewTestSession(map[string]interface{}{"user": "bob"})
+	if err := store.Save(req, sess); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Count(); n != 1 {
+		t.Errorf("Count = %d after a save, want 1", n)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the session survives the database being closed and reopened
+	store, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	got, ok := store.LoadByID(sess.ID())
+	if !ok {
+		t.Fatal("saved session doesn't load after reopening the database")
+	}
+	if user, _ := got.Data()["user"].(string); user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+
+	store.Destroy(req, got)
+	if _, ok := store.LoadByID(sess.ID()); ok {
+		t.Error("destroyed session loaded")
+	}
+	if n := store.Count(); n != 0 {
+		t.Errorf("Count = %d after a Destroy, want 0", n)
+	}
+}
+
+func TestBoltStoreExpiry(t *testing.T) {
+	c := &clock{t: time.Now()}
+	store, err := New(filepath.Join(t.TempDir(), "sessions.db"), session.MaxAge(time.Minute), session.WithClock(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	req, sess := session.NewTestSession(map[string]interface{}{"user": "bob"})
+	if err := store.Save(req, sess); err != nil {
+		t.Fatal(err)
+	}
+	c.advance(2 * time.Minute)
+	if _, ok := store.LoadByID(sess.ID()); ok {
+		t.Error("session loaded past its max age")
+	}
+}
@@ -0,0 +1,245 @@
+package session
+
+import (
+	"container/list"
+	"log"
+	"sync"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a session store that keeps the most recently used sessions of a slower store
+//in memory, loads are served from the cache when they can be, and saves are
+//written through to the backend
+//the cache isn't told about changes other servers make to the backend, so use
+//it on a single server or with sticky sessions
+//a store that keeps the session in its cookie has nothing to gain from a cache,
+//and can't be wrapped
+type CachingStore struct {
+	sweeper
+	//only the sweep options apply to the cache, the rest are the backend's
+	config  storeConfig
+	backend SessionManager
+	size    int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	//most recently used at the front
+	lru *list.List
+}
+
+//ctor, holds up to size sessions from backend in memory
+//the backend keeps running its own sweep, the CachingStore's Sweep only
+//evicts expired sessions from the cache, SweepInterval and WithStats set how
+//often it runs and where its figures go
+func NewCachingStore(backend SessionManager, size int, opts ...Option) *CachingStore {
+	s := &CachingStore{
+		sweeper: newSweeper(),
+		config:  newStoreConfig(opts),
+		backend: backend,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	go s.Sweep()
+	return s
+}
+
+//the backend's hooks for sessions it hands out, cached sessions go through them
+//on each load, promoted from the backend's storeConfig
+type cacheable interface {
+	loaded(req *web.Request, sess *Session) *Session
+	expired(sess *Session) bool
+	loadID(req *web.Request) string
+}
+
+//the cached session for id, nil if it's not cached or has expired
+func (s *CachingStore) get(id string) *Session {
+	var sess *Session
+	s.mu.Lock()
+	if e, ok := s.entries[id]; ok {
+		s.lru.MoveToFront(e)
+		sess = e.Value.(*Session)
+	}
+	s.mu.Unlock()
+	if sess == nil {
+		return nil
+	}
+	if s.stale(sess) {
+		s.remove(id)
+		return nil
+	}
+	return sess
+}
+
+func (s *CachingStore) put(sess *Session) {
+	if s.size <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[sess.id]; ok {
+		e.Value = sess
+		s.lru.MoveToFront(e)
+		return
+	}
+	s.entries[sess.id] = s.lru.PushFront(sess)
+	for s.lru.Len() > s.size {
+		e := s.lru.Back()
+		s.lru.Remove(e)
+		delete(s.entries, e.Value.(*Session).id)
+	}
+}
+
+func (s *CachingStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[id]; ok {
+		s.lru.Remove(e)
+		delete(s.entries, id)
+	}
+}
+
+//whether a cached session has expired, the session is read locked
+//the cache mustn't be locked, as Save is called with the session locked
+func (s *CachingStore) stale(sess *Session) bool {
+	c, ok := s.backend.(cacheable)
+	if !ok {
+		return false
+	}
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	return c.expired(sess)
+}
+
+//the session id the client sent, checked as the backend would check it, see loadID
+func (s *CachingStore) loadID(req *web.Request) string {
+	if c, ok := s.backend.(cacheable); ok {
+		return c.loadID(req)
+	}
+	return requestID(req)
+}
+
+func (s *CachingStore) Load(req *web.Request) *Session {
+	if sess := s.get(s.loadID(req)); sess != nil {
+		if c, ok := s.backend.(cacheable); ok {
+			//sliding expiry and Bind still apply to cached sessions
+			return c.loaded(req, sess)
+		}
+		return sess
+	}
+
+	sess := s.backend.Load(req)
+	if sess != nil && sess.id != "" {
+		s.put(sess)
+	}
+	return sess
+}
+
+//load the sessions with the given ids into the cache ahead of traffic, after a
+//restart say, returns how many were found
+//the backend has to be an IDLoader, with any other backend Warm does nothing
+func (s *CachingStore) Warm(ids []string) int {
+	loader, ok := s.backend.(IDLoader)
+	if !ok {
+		return 0
+	}
+	n := 0
+	for _, id := range ids {
+		if sess, ok := loader.LoadByID(id); ok {
+			s.put(sess)
+			n++
+		}
+	}
+	return n
+}
+
+//write the session through to the backend, it's only cached once the backend has it
+func (s *CachingStore) Save(req *web.Request, sess *Session) error {
+	if err := s.backend.Save(req, sess); err != nil {
+		s.remove(sess.id)
+		return err
+	}
+	s.put(sess)
+	return nil
+}
+
+func (s *CachingStore) Destroy(req *web.Request, sess *Session) {
+	s.remove(sess.id)
+	s.backend.Destroy(req, sess)
+}
+
+//the backend's MaxAge, so the session cookie lasts as long as the backend's sessions
+func (s *CachingStore) MaxAge() time.Duration {
+	if m, ok := s.backend.(maxAger); ok {
+		return m.MaxAge()
+	}
+	return 0
+}
+
+//when the backend expires sess, see expirer, zero if the backend can't say
+func (s *CachingStore) expiresAt(sess *Session) time.Time {
+	if e, ok := s.backend.(expirer); ok {
+		return e.expiresAt(sess)
+	}
+	return time.Time{}
+}
+
+//watch the backend's destroyed sessions, see destroyWatcher
+func (s *CachingStore) watchDestroy(fn func(id string)) {
+	if w, ok := s.backend.(destroyWatcher); ok {
+		w.watchDestroy(fn)
+	}
+}
+
+//the backend's clock, see clocked
+func (s *CachingStore) now() time.Time {
+	if c, ok := s.backend.(clocked); ok {
+		return c.now()
+	}
+	return time.Now()
+}
+
+//evict the expired sessions from the cache
+//Sweep runs until the store is closed
+func (s *CachingStore) Sweep() {
+	tick, stopTicker := s.config.sweepTicker()
+	defer stopTicker()
+	for {
+		beg := time.Now()
+		l, i := s.sweep()
+		taken := time.Since(beg)
+
+		if s.config.stats != nil {
+			s.config.stats(l, i, taken)
+		} else {
+			log.Printf("session cache had %d cached sessions, but evicted %d sessions. took %v ms",
+				l, i, int64(taken/time.Millisecond))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-tick:
+		}
+	}
+}
+
+//a single sweep pass, returns the number of sessions cached and the number evicted
+func (s *CachingStore) sweep() (int, int) {
+	s.mu.Lock()
+	cached := make([]*Session, 0, s.lru.Len())
+	for e := s.lru.Front(); e != nil; e = e.Next() {
+		cached = append(cached, e.Value.(*Session))
+	}
+	s.mu.Unlock()
+
+	i := 0
+	for _, sess := range cached {
+		if s.stale(sess) {
+			s.remove(sess.id)
+			i++
+		}
+	}
+	return len(cached), i
+}
@@ -0,0 +1,105 @@
+package session
+
+import (
+	"sync"
+	"testing"
+	"github.com/garyburd/twister/web"
+)
+
+//a RedisStore counting the loads that reach it, it keeps its unexported hooks
+//so the cache treats it as it would the real thing
+type countingStore struct {
+	*RedisStore
+	mu    sync.Mutex
+	loads int
+}
+
+func (s *countingStore) Load(req *web.Request) *Session {
+	s.mu.Lock()
+	s.loads++
+	s.mu.Unlock()
+	return s.RedisStore.Load(req)
+}
+
+func (s *countingStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loads
+}
+
+func TestCachingStore(t *testing.T) {
+	client := newFakeRedis()
+	backend := &countingStore{RedisStore: RedisStoreWithClient(client)}
+	store := NewCachingStore(backend, 10)
+	defer store.Close()
+	id := seed(t, store, map[string]interface{}{"n": 1})
+	before := backend.count()
+
+	var n int
+	serve(store, func(req *web.Request) {
+		n, _ = Increment(req, "n", 1)
+	}, sessionCookieName+"="+id)
+	if n != 2 {
+		t.Errorf("cached session gave n = %d, want 2", n)
+	}
+	if l := backend.count() - before; l != 0 {
+		t.Errorf("backend loaded %d times for a cached session", l)
+	}
+
+	//written through, so the backend has the increment too
+	sess, ok := backend.LoadByID(id)
+	if !ok {
+		t.Fatal("backend doesn't have the cached session")
+	}
+	if v, _ := sess.Data()["n"].(int); v != 2 {
+		t.Errorf("backend has n = %v, want 2", sess.Data()["n"])
+	}
+}
+
+func TestCachingStoreMiss(t *testing.T) {
+	backend := &countingStore{RedisStore: RedisStoreWithClient(newFakeRedis())}
+	id := seed(t, backend, map[string]interface{}{"user": "bob"})
+	store := NewCachingStore(backend, 10)
+	defer store.Close()
+	before := backend.count()
+
+	for i := 0; i < 2; i++ {
+		var user string
+		serve(store, func(req *web.Request) {
+			Get(req, "user", &user)
+		}, sessionCookieName+"="+id)
+		if user != "bob" {
+			t.Errorf("load %d gave user %q, want bob", i, user)
+		}
+	}
+	if l := backend.count() - before; l != 1 {
+		t.Errorf("backend loaded %d times, want once to fill the cache", l)
+	}
+}
+
+func TestCachingStoreWarm(t *testing.T) {
+	backend := &countingStore{RedisStore: RedisStoreWithClient(newFakeRedis())}
+	ids := []string{
+		seed(t, backend, map[string]interface{}{"n": 1}),
+		seed(t, backend, map[string]interface{}{"n": 2}),
+	}
+	store := NewCachingStore(backend, 10)
+	defer store.Close()
+	if n := store.Warm(append(ids, "0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e")); n != 2 {
+		t.Errorf("Warm found %d sessions, want 2", n)
+	}
+
+	before := backend.count()
+	for i, id := range ids {
+		var n int
+		serve(store, func(req *web.Request) {
+			n, _ = GetInt(req, "n")
+		}, sessionCookieName+"="+id)
+		if n != i+1 {
+			t.Errorf("warmed session gave n = %d, want %d", n, i+1)
+		}
+	}
+	if l := backend.count() - before; l != 0 {
+		t.Errorf("backend loaded %d times for warmed sessions", l)
+	}
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"github.com/garyburd/twister/web"
+)
+
+//the CQL the CassandraStore needs, see the cassandrastore package for these over
+//gocql, tests can use a fake
+//Scan runs a query and scans the first row's columns into dest, returning ErrNotFound
+//when there's no row
+//ExecCAS runs a lightweight transaction, a statement with an IF clause, returning
+//whether it was applied
+type CassandraSession interface {
+	Exec(stmt string, args ...interface{}) error
+	Scan(stmt string, args []interface{}, dest ...interface{}) error
+	ExecCAS(stmt string, args ...interface{}) (bool, error)
+}
+
+//a Cassandra backed session store, each session is a row in a table with the columns
+//(id text PRIMARY KEY, data blob, version bigint), see CreateTable
+//saves are lightweight transactions on the version, so they cost a Paxos round
+//rows are written with a TTL of the session's lifetime, so Cassandra deletes them itself
+type CassandraStore struct {
+	storeConfig
+	session CassandraSession
+	table   string
+}
+
+//ctor, table must be a plain identifier since it can't be passed as a query parameter
+//cassandrastore.New makes one over a gocql session
+func NewCassandraStore(session CassandraSession, table string, opts ...Option) (*CassandraStore, error) {
+	if !validIdentifier(table) {
+		return nil, errors.New("session: invalid table name " + table)
+	}
+	return &CassandraStore{storeConfig: newStoreConfig(opts), session: session, table: table}, nil
+}
+
+//create the session table if it doesn't already exist
+func (s *CassandraStore) CreateTable() error {
+	return s.session.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id text PRIMARY KEY, data blob, version bigint)", s.table))
+}
+
+//a backend that can't be read is handled as the store's FailureMode says
+func (s *CassandraStore) Load(req *web.Request) *Session {
+	return s.loadChecked(req, s)
+}
+
+//the live session stored under id, see IDLoader
+func (s *CassandraStore) LoadByID(id string) (*Session, bool) {
+	return s.loadKVByID(s, "cassandra", id)
+}
+
+//LoadByID, with the error when the backend couldn't be read, see CheckedLoader
+func (s *CassandraStore) LoadChecked(id string) (*Session, error) {
+	return s.loadKV(s, id)
+}
+
+//the row is written with a TTL of what's left of the session's lifetime
+func (s *CassandraStore) Save(req *web.Request, sess *Session) error {
+	return s.saveKV(s, sess)
+}
+
+//the encoded session under id, with its version, see kvBackend
+func (s *CassandraStore) get(id string) (kvEntry, error) {
+	var e kvEntry
+	q := fmt.Sprintf("SELECT data, version FROM %s WHERE id = ?", s.table)
+	if err := s.session.Scan(q, []interface{}{id}, &e.b, &e.rev); err == ErrNotFound {
+		return kvEntry{}, nil
+	} else if err != nil {
+		return kvEntry{}, err
+	}
+	return e, nil
+}
+
+func (s *CassandraStore) put(sess *Session, b []byte, ttl int64, prev kvEntry) error {
+	var applied bool
+	var err error
+	if prev.rev == 0 {
+		q := fmt.Sprintf("INSERT INTO %s (id, data, version) VALUES (?, ?, ?) IF NOT EXISTS USING TTL ?", s.table)
+		applied, err = s.session.ExecCAS(q, sess.id, b, sess.version, int(ttl))
+	} else {
+		q := fmt.Sprintf("UPDATE %s USING TTL ? SET data = ?, version = ? WHERE id = ? IF version = ?", s.table)
+		applied, err = s.session.ExecCAS(q, int(ttl), b, sess.version, sess.id, prev.rev)
+	}
+	if err == nil && !applied {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *CassandraStore) Destroy(req *web.Request, sess *Session) {
+	q := fmt.Sprintf("DELETE FROM %s WHERE id = ?", s.table)
+	if err := s.session.Exec(q, sess.id); err != nil {
+		log.Printf("session: cassandra delete of %s failed: %v", sess.id, err)
+	}
+	s.destroyed(sess)
+}
+
+//cassandra expires the rows itself, so there's nothing to sweep
+func (s *CassandraStore) Sweep() {}
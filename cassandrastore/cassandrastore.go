@@ -0,0 +1,55 @@
+//Cassandra tables for the session package's CassandraStore, through gocql, kept in
+//a package of their own so only programs using Cassandra depend on the driver
+//	cluster := gocql.NewCluster("127.0.0.1")
+//	cluster.Keyspace = "app"
+//	cs, err := cluster.CreateSession()
+//	store, err := cassandrastore.New(cs, "sessions", session.MaxAge(time.Hour))
+//	err = store.CreateTable()
+package cassandrastore
+
+import (
+	"context"
+	"time"
+	"github.com/gocql/gocql"
+	"github.com/nstott/session"
+)
+
+//how long each query gets, the store's methods don't take a context
+const timeout = 5 * time.Second
+
+//ctor, sessions are kept in table, which CreateTable makes
+func New(cs *gocql.Session, table string, opts ...session.Option) (*session.CassandraStore, error) {
+	return session.NewCassandraStore(cqlSession{cs}, table, opts...)
+}
+
+//session.CassandraSession over gocql
+type cqlSession struct {
+	cs *gocql.Session
+}
+
+func (c cqlSession) Exec(stmt string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.cs.Query(stmt, args...).WithContext(ctx).Exec()
+}
+
+func (c cqlSession) Scan(stmt string, args []interface{}, dest ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return notFound(c.cs.Query(stmt, args...).WithContext(ctx).Scan(dest...))
+}
+
+//the existing row's columns, when it isn't applied, aren't wanted
+func (c cqlSession) ExecCAS(stmt string, args ...interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.cs.Query(stmt, args...).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+}
+
+//gocql's error for a query returning no rows is the store's ErrNotFound
+func notFound(err error) error {
+	if err == gocql.ErrNotFound {
+		return session.ErrNotFound
+	}
+	return err
+}
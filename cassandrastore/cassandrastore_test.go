@@ -0,0 +1,21 @@
+package cassandrastore
+
+import (
+	"errors"
+	"testing"
+	"github.com/gocql/gocql"
+	"github.com/nstott/session"
+)
+
+func TestNotFound(t *testing.T) {
+	if err := notFound(gocql.ErrNotFound); err != session.ErrNotFound {
+		t.Errorf("gocql.ErrNotFound became %v, want session.ErrNotFound", err)
+	}
+	if err := notFound(nil); err != nil {
+		t.Errorf("no error became %v", err)
+	}
+	timeout := errors.New("gocql: no response received from cassandra within timeout period")
+	if err := notFound(timeout); err != timeout {
+		t.Errorf("%v became %v", timeout, err)
+	}
+}
@@ -0,0 +1,135 @@
+package session
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a row in a fakeCassandra table
+type cassandraRow struct {
+	data    []byte
+	ttl     int
+	version int64
+}
+
+//a CassandraSession understanding the store's statements, keeping one table's rows
+type fakeCassandra struct {
+	mu    sync.Mutex
+	rows  map[string]cassandraRow
+	stmts []string
+	err   error
+}
+
+func newFakeCassandra() *fakeCassandra {
+	return &fakeCassandra{rows: make(map[string]cassandraRow)}
+}
+
+func (c *fakeCassandra) Exec(stmt string, args ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.stmts = append(c.stmts, stmt)
+	if strings.HasPrefix(stmt, "DELETE") {
+		delete(c.rows, args[0].(string))
+	}
+	return nil
+}
+
+func (c *fakeCassandra) Scan(stmt string, args []interface{}, dest ...interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	row, ok := c.rows[args[0].(string)]
+	if !ok {
+		return ErrNotFound
+	}
+	*dest[0].(*[]byte) = row.data
+	*dest[1].(*int64) = row.version
+	return nil
+}
+
+func (c *fakeCassandra) ExecCAS(stmt string, args ...interface{}) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return false, c.err
+	}
+	c.stmts = append(c.stmts, stmt)
+	switch {
+	case strings.HasPrefix(stmt, "INSERT") && strings.Contains(stmt, "IF NOT EXISTS"):
+		id := args[0].(string)
+		if _, ok := c.rows[id]; ok {
+			return false, nil
+		}
+		c.rows[id] = cassandraRow{append([]byte(nil), args[1].([]byte)...), args[3].(int), args[2].(int64)}
+	case strings.HasPrefix(stmt, "UPDATE") && strings.Contains(stmt, "IF version = ?"):
+		id := args[3].(string)
+		if row, ok := c.rows[id]; !ok || row.version != args[4].(int64) {
+			return false, nil
+		}
+		c.rows[id] = cassandraRow{append([]byte(nil), args[1].([]byte)...), args[0].(int), args[2].(int64)}
+	default:
+		return false, errors.New("fakeCassandra: unexpected statement " + stmt)
+	}
+	return true, nil
+}
+
+func TestCassandraStore(t *testing.T) {
+	cs := newFakeCassandra()
+	store, err := NewCassandraStore(cs, "sessions", MaxAge(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	row, ok := cs.rows[id]
+	if !ok {
+		t.Fatal("session not inserted")
+	}
+	if row.ttl != 3600 {
+		t.Errorf("row inserted with a TTL of %d, want 3600", row.ttl)
+	}
+	insert := cs.stmts[len(cs.stmts)-1]
+	if !strings.Contains(insert, "INSERT INTO sessions") || !strings.Contains(insert, "USING TTL ?") {
+		t.Errorf("session saved with %q", insert)
+	}
+
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+		Destroy(req)
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+	if _, ok := cs.rows[id]; ok {
+		t.Error("destroyed session still in the table")
+	}
+}
+
+func TestCassandraStoreMiss(t *testing.T) {
+	cs := newFakeCassandra()
+	store, err := NewCassandraStore(cs, "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := "0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e"
+	if _, err := store.LoadChecked(id); err != ErrNotFound {
+		t.Errorf("LoadChecked of a missing row = %v, want ErrNotFound", err)
+	}
+	cs.err = errors.New("gocql: no hosts available in the pool")
+	if _, err := store.LoadChecked(id); err == nil || err == ErrNotFound {
+		t.Errorf("LoadChecked with cassandra down = %v, want its error", err)
+	}
+	if _, err := NewCassandraStore(cs, "sessions; DROP TABLE users"); err == nil {
+		t.Error("table name with CQL in it accepted")
+	}
+}
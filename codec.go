@@ -0,0 +1,160 @@
+package session
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+//a Codec turns a session into bytes and back, for the stores that persist
+//whole sessions (file, redis, secure cookie)
+type Codec interface {
+	Marshal(sess *Session) ([]byte, error)
+	Unmarshal(b []byte) (*Session, error)
+}
+
+//the serialized form of a session
+type storedSession struct {
+	Id        string
+	Data      map[string]interface{}
+	Timestamp time.Time
+	MaxAge    time.Duration
+	//zero for sessions stored before it was recorded
+	CreatedAt time.Time
+	Saves     int
+	Version   int64
+}
+
+func toStored(sess *Session) *storedSession {
+	return &storedSession{Id: sess.id, Data: sess.data, Timestamp: sess.timestamp, MaxAge: sess.maxAge,
+		CreatedAt: sess.createdAt, Saves: sess.saves,
+		Version: sess.version}
+}
+
+func (ss *storedSession) session() *Session {
+	if ss.Data == nil {
+		ss.Data = make(map[string]interface{})
+	}
+	return &Session{id: ss.Id, data: ss.Data, timestamp: ss.Timestamp, maxAge: ss.MaxAge,
+		createdAt: ss.CreatedAt, saves: ss.Saves,
+		version: ss.Version}
+}
+
+//encodes sessions with encoding/gob, values keep their exact types
+//values are held as interface{}, so gob has to be told about any type other than
+//the basic ones, a struct or a slice of them say, pass RegisterTypes to the store
+//this is the default codec
+type GobCodec struct{}
+
+func (GobCodec) Marshal(sess *Session) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(toStored(sess)); err != nil {
+		return nil, gobError(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(b []byte) (*Session, error) {
+	var ss storedSession
+	if err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(&ss); err != nil {
+		return nil, gobError(err)
+	}
+	return ss.session(), nil
+}
+
+//gob's error for a type it hasn't been told about doesn't say what to do about it
+func gobError(err error) error {
+	if strings.Contains(err.Error(), "not registered") {
+		return fmt.Errorf("session: %w, register the type with RegisterTypes", err)
+	}
+	return err
+}
+
+//encodes sessions with encoding/json, which is readable by other languages but loses
+//the concrete types of values: all numbers come back as float64, and structs as
+//map[string]interface{}
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(sess *Session) ([]byte, error) {
+	return json.Marshal(toStored(sess))
+}
+
+func (JSONCodec) Unmarshal(b []byte) (*Session, error) {
+	var ss storedSession
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return nil, err
+	}
+	return ss.session(), nil
+}
+
+//the first byte of a FlateCodec encoding, saying whether the rest is compressed
+const (
+	flateRaw        byte = 0
+	flateCompressed byte = 1
+)
+
+//wraps another codec, compressing sessions that encode to Threshold bytes or more
+//with compress/flate, smaller ones aren't worth the cost and are stored as they are
+//the encoding starts with a byte saying which was done, so it can't read sessions
+//stored by the wrapped codec alone
+type FlateCodec struct {
+	//the codec doing the encoding, GobCodec when nil
+	Codec     Codec
+	Threshold int
+}
+
+func (c FlateCodec) inner() Codec {
+	if c.Codec == nil {
+		return GobCodec{}
+	}
+	return c.Codec
+}
+
+func (c FlateCodec) Marshal(sess *Session) ([]byte, error) {
+	b, err := c.inner().Marshal(sess)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < c.Threshold {
+		return append([]byte{flateRaw}, b...), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(flateCompressed)
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c FlateCodec) Unmarshal(b []byte) (*Session, error) {
+	if len(b) == 0 {
+		return nil, errors.New("session: empty flate encoded session")
+	}
+	switch b[0] {
+	case flateRaw:
+		return c.inner().Unmarshal(b[1:])
+	case flateCompressed:
+		r := flate.NewReader(bytes.NewReader(b[1:]))
+		defer r.Close()
+		raw, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return c.inner().Unmarshal(raw)
+	}
+	return nil, errors.New("session: unknown flate encoding")
+}
@@ -0,0 +1,103 @@
+package session
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"github.com/garyburd/twister/web"
+)
+
+//a session's data through codec and back
+func roundTrip(t *testing.T, codec Codec, data map[string]interface{}) map[string]interface{} {
+	sess := NewSessionWithID("id")
+	sess.data = data
+	b, err := codec.Marshal(sess)
+	if err != nil {
+		t.Fatalf("%T: %v", codec, err)
+	}
+	got, err := codec.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("%T: %v", codec, err)
+	}
+	if got.id != "id" {
+		t.Errorf("%T: id %q came back as %q", codec, "id", got.id)
+	}
+	return got.data
+}
+
+func TestCodecTypes(t *testing.T) {
+	data := map[string]interface{}{"n": 3, "s": "x"}
+	if got := roundTrip(t, GobCodec{}, data); !reflect.DeepEqual(got, data) {
+		t.Errorf("gob gave %#v, want %#v", got, data)
+	}
+	//json has only the one number type
+	want := map[string]interface{}{"n": float64(3), "s": "x"}
+	if got := roundTrip(t, JSONCodec{}, data); !reflect.DeepEqual(got, want) {
+		t.Errorf("json gave %#v, want %#v", got, want)
+	}
+}
+
+func TestFlateCodec(t *testing.T) {
+	codec := FlateCodec{Codec: JSONCodec{}, Threshold: 256}
+	big := map[string]interface{}{"s": strings.Repeat("session ", 1000)}
+	sess := NewSessionWithID("id")
+	sess.data = big
+	raw, _ := JSONCodec{}.Marshal(sess)
+	b, err := codec.Marshal(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != flateCompressed || len(b) >= len(raw)/2 {
+		t.Errorf("%d byte session encoded to %d bytes, want it compressed", len(raw), len(b))
+	}
+	if got := roundTrip(t, codec, big); !reflect.DeepEqual(got, big) {
+		t.Error("compressed session didn't come back the same")
+	}
+
+	small := map[string]interface{}{"s": "x"}
+	sess.data = small
+	if b, _ := codec.Marshal(sess); b[0] != flateRaw {
+		t.Error("session under the threshold was compressed")
+	}
+	if got := roundTrip(t, codec, small); !reflect.DeepEqual(got, small) {
+		t.Errorf("raw session came back as %#v", got)
+	}
+}
+
+type registeredCart struct {
+	Items []string
+}
+
+//never registered, gob's registry is global so it has to be a type of its own
+type unregisteredCart struct {
+	Items []string
+}
+
+func TestRegisterTypes(t *testing.T) {
+	store, err := NewFileStore(t.TempDir(), RegisterTypes(registeredCart{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	id := seed(t, store, map[string]interface{}{"cart": registeredCart{[]string{"tea"}}})
+	sess, ok := store.LoadByID(id)
+	if !ok {
+		t.Fatal("session holding a registered struct didn't load")
+	}
+	if cart, ok := sess.Data()["cart"].(registeredCart); !ok || !reflect.DeepEqual(cart.Items, []string{"tea"}) {
+		t.Errorf("cart came back as %#v", sess.Data()["cart"])
+	}
+
+	sess = NewSessionWithID("id")
+	sess.data["cart"] = unregisteredCart{[]string{"tea"}}
+	_, err = GobCodec{}.Marshal(sess)
+	if err == nil || !strings.Contains(err.Error(), "RegisterTypes") {
+		t.Errorf("encoding an unregistered struct gave %v, want an error naming RegisterTypes", err)
+	}
+	rec := serve(store, func(req *web.Request) {
+		Set(req, "cart", unregisteredCart{[]string{"tea"}})
+	})
+	if c := setCookie(rec, sessionCookieName); c != "" {
+		t.Errorf("cookie %q sent for a session that couldn't be saved", c)
+	}
+}
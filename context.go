@@ -0,0 +1,60 @@
+package session
+
+import (
+	"context"
+	"github.com/garyburd/twister/web"
+)
+
+//the context key for the session, unexported so other packages can't collide with it
+type contextKey struct{}
+
+//the context key marking the session read only, see MarkReadOnly
+type readOnlyKey struct{}
+
+//a copy of ctx carrying sess
+func NewContext(ctx context.Context, sess *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, sess)
+}
+
+//the session carried by ctx
+func FromContext(ctx context.Context) (*Session, bool) {
+	sess, ok := ctx.Value(contextKey{}).(*Session)
+	return sess, ok
+}
+
+//a context carrying the request's session, for code that doesn't have the request
+//it's background context if the request didn't go through the session handler
+func Context(req *web.Request) context.Context {
+	if req == nil {
+		return context.Background()
+	}
+	//with LazyLoad the session may not have been loaded yet
+	Current(req)
+	if ctx, ok := req.Env["sessionContext"].(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+//Get for a session carried by a context
+func GetContext(ctx context.Context, key string, ret interface{}) bool {
+	sess, ok := FromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	val, ok := sess.get(key)
+	if !ok {
+		return false
+	}
+	return assign(ret, val)
+}
+
+//Set for a session carried by a context
+func SetContext(ctx context.Context, key string, value interface{}) bool {
+	sess, ok := FromContext(ctx)
+	if ro, _ := ctx.Value(readOnlyKey{}).(bool); !ok || ro {
+		return false
+	}
+	return sess.set(key, value)
+}
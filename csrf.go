@@ -0,0 +1,62 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"github.com/garyburd/twister/web"
+)
+
+//the CSRF token is kept in the session data under this key
+const csrfKey = "_csrf"
+
+//the session's CSRF token, for embedding in forms, a token is generated
+//on first use and kept for the life of the session
+//returns "" when there's no session
+func CSRFToken(req *web.Request) string {
+	sess, ok := Current(req)
+	if !ok {
+		return ""
+	}
+
+	sess.mu.RLock()
+	token, _ := sess.data[csrfKey].(string)
+	sess.mu.RUnlock()
+	if token != "" || readOnly(req) {
+		return token
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("session: could not generate a csrf token: %v", err)
+		return ""
+	}
+	fresh := base64.RawURLEncoding.EncodeToString(b)
+	stored := sess.update(func() bool {
+		//another request may have got there first
+		if token, _ = sess.data[csrfKey].(string); token != "" {
+			return false
+		}
+		sess.data[csrfKey] = fresh
+		return true
+	})
+	if stored {
+		return fresh
+	}
+	return token
+}
+
+//whether token matches the session's CSRF token, compared in constant time
+//it's always false before CSRFToken has given the session a token
+func ValidateCSRF(req *web.Request, token string) bool {
+	sess, ok := Current(req)
+	if !ok || token == "" {
+		return false
+	}
+
+	sess.mu.RLock()
+	want, _ := sess.data[csrfKey].(string)
+	sess.mu.RUnlock()
+	return want != "" && subtle.ConstantTimeCompare([]byte(want), []byte(token)) == 1
+}
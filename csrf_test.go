@@ -0,0 +1,28 @@
+package session
+
+import "testing"
+
+func TestCSRFToken(t *testing.T) {
+	req, _ := NewTestSession(nil)
+	if ValidateCSRF(req, "anything") {
+		t.Error("token accepted before one was issued")
+	}
+	token := CSRFToken(req)
+	if token == "" {
+		t.Fatal("no token issued")
+	}
+	if again := CSRFToken(req); again != token {
+		t.Errorf("token changed within the session, %q then %q", token, again)
+	}
+	if !ValidateCSRF(req, token) {
+		t.Error("the session's token failed validation")
+	}
+	if ValidateCSRF(req, token[1:]) || ValidateCSRF(req, "") {
+		t.Error("a wrong token passed validation")
+	}
+
+	other, _ := NewTestSession(nil)
+	if CSRFToken(other) == token || ValidateCSRF(other, token) {
+		t.Error("token shared between sessions")
+	}
+}
@@ -0,0 +1,44 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/garyburd/twister/web"
+)
+
+//DebugHandler only answers when this is set, so a debug route left in the router
+//shows nothing in production, turn it on from a development config or flag
+var DebugEnabled = false
+
+//a handler writing the request's session data as a JSON object, for mounting on a
+//debug route behind the session handler, it answers 404 unless DebugEnabled is set
+//the CSRF token and the Bind fingerprint are left out, and the object is empty when
+//there's no session
+func DebugHandler() web.Handler {
+	return web.HandlerFunc(serveDebug)
+}
+
+func serveDebug(req *web.Request) {
+	if !DebugEnabled {
+		req.Error(web.StatusNotFound, errors.New("session debugging is disabled"))
+		return
+	}
+
+	view := make(map[string]interface{})
+	if sess, ok := Current(req); ok {
+		sess.mu.RLock()
+		for k, v := range sess.data {
+			if k != csrfKey && k != fingerprintKey {
+				view[k] = v
+			}
+		}
+		sess.mu.RUnlock()
+	}
+	b, err := json.Marshal(view)
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	w := req.Respond(web.StatusOK, web.HeaderContentType, "application/json; charset=utf-8")
+	w.Write(b)
+}
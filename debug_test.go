@@ -0,0 +1,61 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"github.com/garyburd/twister/web"
+)
+
+//a recorder keeping the response body too
+type bodyRecorder struct {
+	recorder
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Respond(status int, header web.Header) web.ResponseBody {
+	r.recorder.Respond(status, header)
+	return r
+}
+
+func (r *bodyRecorder) Write(p []byte) (int, error) { return r.body.Write(p) }
+func (r *bodyRecorder) Flush() error                { return nil }
+
+//the debug view of the session behind the cookies
+func debugView(t *testing.T, store SessionManager, cookies ...string) (int, map[string]interface{}) {
+	req, _ := newRequest(cookies...)
+	rec := &bodyRecorder{}
+	req.Responder = rec
+	NewSessionHandler(store, DebugHandler(), Config{}).ServeWeb(req)
+	if rec.status != web.StatusOK {
+		return rec.status, nil
+	}
+	var view map[string]interface{}
+	if err := json.Unmarshal(rec.body.Bytes(), &view); err != nil {
+		t.Fatalf("debug view %q isn't JSON: %v", rec.body.String(), err)
+	}
+	return rec.status, view
+}
+
+func TestDebugHandler(t *testing.T) {
+	store := MemoryStoreNoSweep()
+	rec := serve(store, func(req *web.Request) {
+		Set(req, "user", "bob")
+		CSRFToken(req)
+	})
+	id := cookieValue(rec, sessionCookieName)
+
+	if status, _ := debugView(t, store, sessionCookieName+"="+id); status != web.StatusNotFound {
+		t.Errorf("debug handler answered %d while disabled, want 404", status)
+	}
+
+	DebugEnabled = true
+	defer func() { DebugEnabled = false }()
+	if _, view := debugView(t, store, sessionCookieName+"="+id); !reflect.DeepEqual(view, map[string]interface{}{"user": "bob"}) {
+		t.Errorf("debug view = %v, want only the user", view)
+	}
+	if _, view := debugView(t, store); view == nil || len(view) != 0 {
+		t.Errorf("debug view without a session = %v, want {}", view)
+	}
+}
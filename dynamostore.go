@@ -0,0 +1,80 @@
+package session
+
+import (
+	"log"
+	"github.com/garyburd/twister/web"
+)
+
+//the DynamoDB operations the DynamoStore needs, see the dynamostore package for
+//these over the AWS SDK, tests can use a fake
+//items have the string partition key "id", the binary attribute "data", the
+//number attribute "ttl", which should be set as the table's TTL attribute, and
+//the number attribute "version"
+//GetItem returns a nil slice and no error when there's no item
+//PutItem only writes while the item's version is still prev, 0 for an item that
+//mustn't exist, and returns ErrConflict when it isn't
+type DynamoClient interface {
+	GetItem(table, id string) (data []byte, ttl, version int64, err error)
+	PutItem(table, id string, data []byte, ttl, version, prev int64) error
+	DeleteItem(table, id string) error
+}
+
+//a DynamoDB backed session store, DynamoDB deletes the items once their ttl passes
+type DynamoStore struct {
+	storeConfig
+	client DynamoClient
+	table  string
+}
+
+//ctor, sessions are kept in table, dynamostore.New makes one over an SDK client
+func NewDynamoStore(client DynamoClient, table string, opts ...Option) *DynamoStore {
+	return &DynamoStore{storeConfig: newStoreConfig(opts), client: client, table: table}
+}
+
+//a backend that can't be read is handled as the store's FailureMode says
+func (s *DynamoStore) Load(req *web.Request) *Session {
+	return s.loadChecked(req, s)
+}
+
+//the live session stored under id, see IDLoader
+func (s *DynamoStore) LoadByID(id string) (*Session, bool) {
+	return s.loadKVByID(s, "dynamo", id)
+}
+
+//LoadByID, with the error when the backend couldn't be read, see CheckedLoader
+func (s *DynamoStore) LoadChecked(id string) (*Session, error) {
+	return s.loadKV(s, id)
+}
+
+func (s *DynamoStore) Save(req *web.Request, sess *Session) error {
+	return s.saveKV(s, sess)
+}
+
+//the encoded session under id, with its version, see kvBackend
+func (s *DynamoStore) get(id string) (kvEntry, error) {
+	b, ttl, version, err := s.client.GetItem(s.table, id)
+	if err != nil {
+		return kvEntry{}, err
+	}
+	//dynamo can take a while to delete expired items, so the ttl is checked too,
+	//the item's still there to be written over though
+	if ttl <= s.now().Unix() {
+		return kvEntry{rev: version}, nil
+	}
+	return kvEntry{b: b, rev: version}, nil
+}
+
+//the item's ttl attribute is the unix time it expires
+func (s *DynamoStore) put(sess *Session, b []byte, ttl int64, prev kvEntry) error {
+	return s.client.PutItem(s.table, sess.id, b, sess.timestamp.Unix()+ttl, sess.version, prev.rev)
+}
+
+func (s *DynamoStore) Destroy(req *web.Request, sess *Session) {
+	if err := s.client.DeleteItem(s.table, sess.id); err != nil {
+		log.Printf("session: dynamo delete of %s failed: %v", sess.id, err)
+	}
+	s.destroyed(sess)
+}
+
+//dynamo expires the items itself, so there's nothing to sweep
+func (s *DynamoStore) Sweep() {}
@@ -0,0 +1,113 @@
+//DynamoDB tables for the session package's DynamoStore, through the AWS SDK, kept in
+//a package of their own so only programs using DynamoDB depend on the SDK
+//	cfg, err := config.LoadDefaultConfig(context.Background())
+//	store := dynamostore.New(dynamodb.NewFromConfig(cfg), "sessions", session.MaxAge(time.Hour))
+//the table needs the string partition key "id", and "ttl" set as its TTL attribute,
+//saves are conditional on the item's "version" attribute
+package dynamostore
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/nstott/session"
+)
+
+//how long each call to DynamoDB gets, the store's methods don't take a context
+const timeout = 5 * time.Second
+
+//the DynamoDB calls the store makes, a *dynamodb.Client has them
+type Client interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+//ctor, sessions are kept in table
+func New(client Client, table string, opts ...session.Option) *session.DynamoStore {
+	return session.NewDynamoStore(dynamoClient{client}, table, opts...)
+}
+
+//session.DynamoClient over the SDK
+type dynamoClient struct {
+	client Client
+}
+
+func key(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}}
+}
+
+func (c dynamoClient) GetItem(table, id string) ([]byte, int64, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := c.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            key(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if out.Item == nil {
+		return nil, 0, 0, nil
+	}
+	data, ok := out.Item["data"].(*types.AttributeValueMemberB)
+	ttl, ok2 := out.Item["ttl"].(*types.AttributeValueMemberN)
+	if !ok || !ok2 {
+		return nil, 0, 0, errors.New("session: dynamo item " + id + " is missing its data or ttl")
+	}
+	n, err := strconv.ParseInt(ttl.Value, 10, 64)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var version int64
+	if v, ok := out.Item["version"].(*types.AttributeValueMemberN); ok {
+		if version, err = strconv.ParseInt(v.Value, 10, 64); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return data.Value, n, version, nil
+}
+
+func (c dynamoClient) PutItem(table, id string, data []byte, ttl, version, prev int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	in := &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"id":      &types.AttributeValueMemberS{Value: id},
+			"data":    &types.AttributeValueMemberB{Value: data},
+			"ttl":     &types.AttributeValueMemberN{Value: strconv.FormatInt(ttl, 10)},
+			"version": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+		},
+	}
+	if prev == 0 {
+		in.ConditionExpression = aws.String("attribute_not_exists(id)")
+	} else {
+		in.ConditionExpression = aws.String("#v = :prev")
+		in.ExpressionAttributeNames = map[string]string{"#v": "version"}
+		in.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":prev": &types.AttributeValueMemberN{Value: strconv.FormatInt(prev, 10)},
+		}
+	}
+	_, err := c.client.PutItem(ctx, in)
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return session.ErrConflict
+	}
+	return err
+}
+
+func (c dynamoClient) DeleteItem(table, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := c.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key:       key(id),
+	})
+	return err
+}
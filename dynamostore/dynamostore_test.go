@@ -0,0 +1,81 @@
+package dynamostore
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/nstott/session"
+)
+
+//a Client keeping one table's items in a map, by id
+type fakeTable struct {
+	items map[string]map[string]types.AttributeValue
+}
+
+func (f *fakeTable) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	id := params.Key["id"].(*types.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: f.items[id]}, nil
+}
+
+func (f *fakeTable) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	id := params.Item["id"].(*types.AttributeValueMemberS).Value
+	item, ok := f.items[id]
+	//the two conditions the store writes with
+	switch aws.ToString(params.ConditionExpression) {
+	case "attribute_not_exists(id)":
+		if ok {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	case "#v = :prev":
+		v, _ := item["version"].(*types.AttributeValueMemberN)
+		if !ok || v == nil || v.Value != params.ExpressionAttributeValues[":prev"].(*types.AttributeValueMemberN).Value {
+			return nil, &types.ConditionalCheckFailedException{}
+		}
+	}
+	f.items[id] = params.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeTable) DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	delete(f.items, params.Key["id"].(*types.AttributeValueMemberS).Value)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestDynamoStore(t *testing.T) {
+	table := &fakeTable{items: make(map[string]map[string]types.AttributeValue)}
+	store := New(table, "sessions", session.MaxAge(time.Hour))
+	req, sess := session.NewTestSession(map[string]interface{}{"user": "bob"})
+	if err := store.Save(req, sess); err != nil {
+		t.Fatal(err)
+	}
+
+	item := table.items[sess.ID()]
+	ttl, _ := item["ttl"].(*types.AttributeValueMemberN)
+	if ttl == nil {
+		t.Fatalf("item %v has no ttl", item)
+	}
+	//the ttl attribute is the unix time the item expires
+	at, err := strconv.ParseInt(ttl.Value, 10, 64)
+	if want := time.Now().Add(time.Hour).Unix(); err != nil || at < want-5 || at > want+5 {
+		t.Errorf("item's ttl is %s, want about %d", ttl.Value, want)
+	}
+	got, ok := store.LoadByID(sess.ID())
+	if !ok {
+		t.Fatal("saved session doesn't load")
+	}
+	if user, _ := got.Data()["user"].(string); user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+
+	store.Destroy(req, got)
+	if _, ok := table.items[sess.ID()]; ok {
+		t.Error("destroyed session still in the table")
+	}
+	if _, ok := store.LoadByID(sess.ID()); ok {
+		t.Error("destroyed session loaded")
+	}
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//an item in a fakeDynamo table
+type dynamoItem struct {
+	data    []byte
+	ttl     int64
+	version int64
+}
+
+//a DynamoClient keeping the items in maps, which never expire them itself
+type fakeDynamo struct {
+	mu     sync.Mutex
+	tables map[string]map[string]dynamoItem
+}
+
+func newFakeDynamo() *fakeDynamo {
+	return &fakeDynamo{tables: make(map[string]map[string]dynamoItem)}
+}
+
+func (d *fakeDynamo) GetItem(table, id string) ([]byte, int64, int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	item := d.tables[table][id]
+	return item.data, item.ttl, item.version, nil
+}
+
+func (d *fakeDynamo) PutItem(table, id string, data []byte, ttl, version, prev int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tables[table] == nil {
+		d.tables[table] = make(map[string]dynamoItem)
+	}
+	if d.tables[table][id].version != prev {
+		return ErrConflict
+	}
+	d.tables[table][id] = dynamoItem{append([]byte(nil), data...), ttl, version}
+	return nil
+}
+
+func (d *fakeDynamo) DeleteItem(table, id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.tables[table], id)
+	return nil
+}
+
+func TestDynamoStore(t *testing.T) {
+	client := newFakeDynamo()
+	clock := newFakeClock()
+	store := NewDynamoStore(client, "sessions", MaxAge(time.Hour), WithClock(clock))
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	item, ok := client.tables["sessions"][id]
+	if !ok {
+		t.Fatal("session not put in the table")
+	}
+	if want := clock.Now().Add(time.Hour).Unix(); item.ttl != want {
+		t.Errorf("item's ttl is %d, want the unix time %d", item.ttl, want)
+	}
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+
+	//dynamo can be slow to delete an expired item
+	clock.advance(time.Hour + time.Second)
+	if _, ok := store.LoadByID(id); ok {
+		t.Error("item past its ttl loaded")
+	}
+}
+
+func TestDynamoStoreMiss(t *testing.T) {
+	store := NewDynamoStore(newFakeDynamo(), "sessions")
+	id := "0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e"
+	if _, err := store.LoadChecked(id); err != ErrNotFound {
+		t.Errorf("LoadChecked of a missing item = %v, want ErrNotFound", err)
+	}
+	var isNew bool
+	serve(store, func(req *web.Request) {
+		isNew = IsNew(req)
+	}, sessionCookieName+"="+id)
+	if !isNew {
+		t.Error("missing item didn't give a new session")
+	}
+}
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"log"
+	"github.com/garyburd/twister/web"
+)
+
+//the etcd operations the EtcdStore needs, see the etcdstore package for these
+//over the etcd v3 client, tests can use a fake
+//PutWithLease grants a lease of ttl seconds and puts the key under it, so etcd
+//deletes the key when the lease runs out, the key's previous lease is revoked,
+//as it is by Delete
+//the put is only made while the key's mod revision is still rev, 0 for a key that
+//mustn't exist, ErrConflict is returned when it isn't
+//Get returns the key's mod revision with its value, a nil slice and no error
+//when the key doesn't exist
+type EtcdClient interface {
+	Get(key string) ([]byte, int64, error)
+	PutWithLease(key string, value []byte, ttl, rev int64) error
+	Delete(key string) error
+}
+
+//an etcd backed session store, each session is kept under /sessions/<id>
+//with a lease that's replaced every time the session is saved
+type EtcdStore struct {
+	storeConfig
+	client EtcdClient
+}
+
+//ctor for an EtcdStore using an already configured client, etcdstore.New makes
+//one over a v3 client
+func NewEtcdStore(client EtcdClient, opts ...Option) *EtcdStore {
+	return &EtcdStore{storeConfig: newStoreConfig(opts), client: client}
+}
+
+//the key a session is stored under in etcd
+func etcdKey(id string) string {
+	return "/sessions/" + id
+}
+
+//a backend that can't be read is handled as the store's FailureMode says
+func (s *EtcdStore) Load(req *web.Request) *Session {
+	return s.loadChecked(req, s)
+}
+
+//the live session stored under id, see IDLoader
+func (s *EtcdStore) LoadByID(id string) (*Session, bool) {
+	return s.loadKVByID(s, "etcd", id)
+}
+
+//LoadByID, with the error when the backend couldn't be read, see CheckedLoader
+func (s *EtcdStore) LoadChecked(id string) (*Session, error) {
+	return s.loadKV(s, id)
+}
+
+//the session is put under a fresh lease for its lifetime
+func (s *EtcdStore) Save(req *web.Request, sess *Session) error {
+	return s.saveKV(s, sess)
+}
+
+//the encoded session under id, with its mod revision, see kvBackend
+func (s *EtcdStore) get(id string) (kvEntry, error) {
+	b, rev, err := s.client.Get(etcdKey(id))
+	return kvEntry{b: b, rev: rev}, err
+}
+
+func (s *EtcdStore) put(sess *Session, b []byte, ttl int64, prev kvEntry) error {
+	return s.client.PutWithLease(etcdKey(sess.id), b, ttl, prev.rev)
+}
+
+func (s *EtcdStore) Destroy(req *web.Request, sess *Session) {
+	if err := s.client.Delete(etcdKey(sess.id)); err != nil {
+		log.Printf("session: etcd delete of %s failed: %v", sess.id, err)
+	}
+	s.destroyed(sess)
+}
+
+//etcd deletes the keys when their leases expire, so there's nothing to sweep
+func (s *EtcdStore) Sweep() {}
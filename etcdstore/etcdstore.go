@@ -0,0 +1,102 @@
+//etcd for the session package's EtcdStore, through the etcd v3 client, kept in a
+//package of their own so only programs using etcd depend on the client
+//	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"localhost:2379"}})
+//	store := etcdstore.New(cli, session.MaxAge(time.Hour))
+package etcdstore
+
+import (
+	"context"
+	"log"
+	"time"
+	"github.com/nstott/session"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+//how long each call to etcd gets, the store's methods don't take a context
+const timeout = 5 * time.Second
+
+//the etcd calls the store makes, a *clientv3.Client has them
+type Client interface {
+	Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error)
+	Txn(ctx context.Context) clientv3.Txn
+	Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error)
+	Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error)
+	Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error)
+}
+
+//ctor
+func New(client Client, opts ...session.Option) *session.EtcdStore {
+	return session.NewEtcdStore(etcdClient{client}, opts...)
+}
+
+//session.EtcdClient over the v3 client
+type etcdClient struct {
+	client Client
+}
+
+func (c etcdClient) Get(key string) ([]byte, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := c.client.Get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, nil
+	}
+	return resp.Kvs[0].Value, resp.Kvs[0].ModRevision, nil
+}
+
+//each save takes out a new lease and revokes the one the key had, a session
+//given a longer max age needs a longer lease than it was first granted, so the
+//old one can't just be kept alive
+//the put is a transaction comparing the key's mod revision, a key that doesn't
+//exist has a mod revision of 0
+func (c etcdClient) PutWithLease(key string, value []byte, ttl, rev int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	lease, err := c.client.Grant(ctx, ttl)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", rev)).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithLease(lease.ID), clientv3.WithPrevKV())).
+		Commit()
+	if err != nil {
+		c.revoke(ctx, lease.ID)
+		return err
+	}
+	if !resp.Succeeded {
+		c.revoke(ctx, lease.ID)
+		return session.ErrConflict
+	}
+	if prev := resp.Responses[0].GetResponsePut().GetPrevKv(); prev != nil {
+		c.revoke(ctx, clientv3.LeaseID(prev.Lease))
+	}
+	return nil
+}
+
+//give up a lease nothing uses any more, one that can't be revoked still runs out
+//on its own
+func (c etcdClient) revoke(ctx context.Context, id clientv3.LeaseID) {
+	if id == clientv3.NoLease {
+		return
+	}
+	if _, err := c.client.Revoke(ctx, id); err != nil {
+		log.Printf("etcdstore: could not revoke lease %x: %v", int64(id), err)
+	}
+}
+
+func (c etcdClient) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := c.client.Delete(ctx, key, clientv3.WithPrevKV())
+	if err != nil {
+		return err
+	}
+	for _, kv := range resp.PrevKvs {
+		c.revoke(ctx, clientv3.LeaseID(kv.Lease))
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package etcdstore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"github.com/nstott/session"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+//a Client keeping the keys in a map, with the ttl of each live lease
+type fakeEtcd struct {
+	keys   map[string]*mvccpb.KeyValue
+	leases map[clientv3.LeaseID]int64
+	next   clientv3.LeaseID
+	rev    int64
+	//called as each transaction commits, when set
+	beforeTxn func()
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{keys: make(map[string]*mvccpb.KeyValue), leases: make(map[clientv3.LeaseID]int64)}
+}
+
+func (f *fakeEtcd) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	resp := &clientv3.GetResponse{}
+	if kv, ok := f.keys[key]; ok {
+		resp.Kvs = []*mvccpb.KeyValue{kv}
+		resp.Count = 1
+	}
+	return resp, nil
+}
+
+func (f *fakeEtcd) Txn(ctx context.Context) clientv3.Txn {
+	return &fakeTxn{etcd: f}
+}
+
+//a transaction comparing mod revisions and making puts, the only kind the store uses
+type fakeTxn struct {
+	etcd *fakeEtcd
+	cmps []clientv3.Cmp
+	ops  []clientv3.Op
+}
+
+func (t *fakeTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.cmps = append(t.cmps, cs...)
+	return t
+}
+
+func (t *fakeTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.ops = append(t.ops, ops...)
+	return t
+}
+
+func (t *fakeTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	panic("fakeEtcd: Else isn't supported")
+}
+
+func (t *fakeTxn) Commit() (*clientv3.TxnResponse, error) {
+	if t.etcd.beforeTxn != nil {
+		t.etcd.beforeTxn()
+	}
+	for _, cmp := range t.cmps {
+		c := cmp.GetCompare()
+		want, ok := c.TargetUnion.(*etcdserverpb.Compare_ModRevision)
+		if !ok || c.Result != etcdserverpb.Compare_EQUAL {
+			return nil, errors.New("fakeEtcd: only mod revision equality is supported")
+		}
+		var rev int64
+		if kv, ok := t.etcd.keys[string(c.Key)]; ok {
+			rev = kv.ModRevision
+		}
+		if rev != want.ModRevision {
+			return &clientv3.TxnResponse{}, nil
+		}
+	}
+	resp := &clientv3.TxnResponse{Succeeded: true}
+	for _, op := range t.ops {
+		put, err := t.etcd.put(op)
+		if err != nil {
+			return nil, err
+		}
+		resp.Responses = append(resp.Responses, &etcdserverpb.ResponseOp{
+			Response: &etcdserverpb.ResponseOp_ResponsePut{ResponsePut: put},
+		})
+	}
+	return resp, nil
+}
+
+func (f *fakeEtcd) put(op clientv3.Op) (*etcdserverpb.PutResponse, error) {
+	if !op.IsPut() {
+		return nil, errors.New("fakeEtcd: only puts are supported")
+	}
+	key := string(op.KeyBytes())
+	//Op doesn't export the lease it was given
+	lease := reflect.ValueOf(op).FieldByName("leaseID").Int()
+	if _, ok := f.leases[clientv3.LeaseID(lease)]; lease != 0 && !ok {
+		return nil, errors.New("etcdserver: requested lease not found")
+	}
+	resp := &etcdserverpb.PutResponse{}
+	if op.IsPrevKV() {
+		resp.PrevKv = f.keys[key]
+	}
+	f.rev++
+	f.keys[key] = &mvccpb.KeyValue{Key: []byte(key), Value: op.ValueBytes(), Lease: lease, ModRevision: f.rev}
+	return resp, nil
+}
+
+func (f *fakeEtcd) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	resp := &clientv3.DeleteResponse{}
+	if kv, ok := f.keys[key]; ok {
+		resp.Deleted = 1
+		if clientv3.OpDelete(key, opts...).IsPrevKV() {
+			resp.PrevKvs = []*mvccpb.KeyValue{kv}
+		}
+	}
+	delete(f.keys, key)
+	return resp, nil
+}
+
+func (f *fakeEtcd) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	f.next++
+	f.leases[f.next] = ttl
+	return &clientv3.LeaseGrantResponse{ID: f.next, TTL: ttl}, nil
+}
+
+//revoking a lease deletes the keys put under it, as etcd does
+func (f *fakeEtcd) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	if _, ok := f.leases[id]; !ok {
+		return nil, errors.New("etcdserver: requested lease not found")
+	}
+	delete(f.leases, id)
+	for k, kv := range f.keys {
+		if clientv3.LeaseID(kv.Lease) == id {
+			delete(f.keys, k)
+		}
+	}
+	return &clientv3.LeaseRevokeResponse{}, nil
+}
+
+func TestEtcdStore(t *testing.T) {
+	client := newFakeEtcd()
+	store := New(client, session.MaxAge(time.Hour))
+	req, sess := session.NewTestSession(map[string]interface{}{"user": "bob"})
+	//each save replaces the lease, so only one is ever held
+	for i := 0; i < 3; i++ {
+		if err := store.Save(req, sess); err != nil {
+			t.Fatal(err)
+		}
+		if len(client.leases) != 1 || client.leases[client.next] != 3600 {
+			t.Errorf("leases held after save %d: %v, want one of 3600 seconds", i+1, client.leases)
+		}
+	}
+	if _, ok := client.keys["/sessions/"+sess.ID()]; !ok {
+		t.Fatal("session not put in etcd")
+	}
+
+	got, ok := store.LoadByID(sess.ID())
+	if !ok {
+		t.Fatal("saved session doesn't load")
+	}
+	if user, _ := got.Data()["user"].(string); user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+
+	store.Destroy(req, got)
+	if _, ok := store.LoadByID(sess.ID()); ok {
+		t.Error("destroyed session loaded")
+	}
+	if len(client.leases) != 0 {
+		t.Errorf("leases held after Destroy: %v", client.leases)
+	}
+}
+
+func TestEtcdStoreMiss(t *testing.T) {
+	store := New(newFakeEtcd())
+	if _, err := store.LoadChecked("0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e"); err != session.ErrNotFound {
+		t.Errorf("LoadChecked of a missing key = %v, want ErrNotFound", err)
+	}
+}
+
+func TestEtcdStoreConflict(t *testing.T) {
+	client := newFakeEtcd()
+	store := New(client, session.MaxAge(time.Hour))
+	req, sess := session.NewTestSession(map[string]interface{}{"n": 0})
+	if err := store.Save(req, sess); err != nil {
+		t.Fatal(err)
+	}
+
+	//another writer puts the key between the store's read and its transaction
+	key := "/sessions/" + sess.ID()
+	client.beforeTxn = func() {
+		client.rev++
+		client.keys[key].ModRevision = client.rev
+	}
+	if err := store.Save(req, sess); err != session.ErrConflict {
+		t.Errorf("saving over a key put since it was read gave %v, want ErrConflict", err)
+	}
+	//the lease granted for the refused put is given back
+	if len(client.leases) != 1 {
+		t.Errorf("leases held after the conflict: %v, want one", client.leases)
+	}
+}
@@ -0,0 +1,90 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//an EtcdClient keeping the keys in a map, with the lease each was last put under
+//and the revision it was put at
+type fakeEtcd struct {
+	mu     sync.Mutex
+	keys   map[string][]byte
+	leases map[string]int64
+	revs   map[string]int64
+	rev    int64
+	err    error
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{keys: make(map[string][]byte), leases: make(map[string]int64), revs: make(map[string]int64)}
+}
+
+func (e *fakeEtcd) Get(key string) ([]byte, int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.keys[key], e.revs[key], e.err
+}
+
+func (e *fakeEtcd) PutWithLease(key string, value []byte, ttl, rev int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+	if e.revs[key] != rev {
+		return ErrConflict
+	}
+	e.rev++
+	e.keys[key] = append([]byte(nil), value...)
+	e.leases[key] = ttl
+	e.revs[key] = e.rev
+	return nil
+}
+
+func (e *fakeEtcd) Delete(key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.keys, key)
+	delete(e.leases, key)
+	delete(e.revs, key)
+	return e.err
+}
+
+func TestEtcdStore(t *testing.T) {
+	client := newFakeEtcd()
+	store := NewEtcdStore(client, MaxAge(time.Hour))
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	if ttl, ok := client.leases["/sessions/"+id]; !ok || ttl != 3600 {
+		t.Errorf("saved under a lease of %d, want 3600", ttl)
+	}
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+		Destroy(req)
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+	if _, ok := client.keys["/sessions/"+id]; ok {
+		t.Error("destroyed session still in etcd")
+	}
+}
+
+func TestEtcdStoreMiss(t *testing.T) {
+	client := newFakeEtcd()
+	store := NewEtcdStore(client)
+	id := "0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e"
+	if _, err := store.LoadChecked(id); err != ErrNotFound {
+		t.Errorf("LoadChecked of a missing key = %v, want ErrNotFound", err)
+	}
+
+	client.err = errors.New("etcdserver: request timed out")
+	if _, err := store.LoadChecked(id); err == nil || err == ErrNotFound {
+		t.Errorf("LoadChecked with etcd down = %v, want its error", err)
+	}
+}
@@ -0,0 +1,83 @@
+package session
+
+import (
+	"container/heap"
+	"time"
+)
+
+//a session id and the time it expires
+type expiryEntry struct {
+	id     string
+	expiry time.Time
+	index  int
+}
+
+//a min-heap of session expiry times, the next session to expire is at the root
+//so a sweep only has to look at the sessions that have actually expired
+//entries are also indexed by id so a session's expiry can be moved when it's saved again
+type expiryIndex struct {
+	entries []*expiryEntry
+	byID    map[string]*expiryEntry
+}
+
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{byID: make(map[string]*expiryEntry)}
+}
+
+//set the expiry of the session id, adding it to the index if needed
+func (x *expiryIndex) set(id string, expiry time.Time) {
+	if e, ok := x.byID[id]; ok {
+		e.expiry = expiry
+		heap.Fix(x, e.index)
+		return
+	}
+	e := &expiryEntry{id: id, expiry: expiry}
+	x.byID[id] = e
+	heap.Push(x, e)
+}
+
+//drop the session id from the index
+func (x *expiryIndex) remove(id string) {
+	if e, ok := x.byID[id]; ok {
+		heap.Remove(x, e.index)
+	}
+}
+
+//pop the next session that has expired by now, returns false when there are none left
+func (x *expiryIndex) popExpired(now time.Time) (string, bool) {
+	if len(x.entries) == 0 || !x.entries[0].expiry.Before(now) {
+		return "", false
+	}
+	e := heap.Pop(x).(*expiryEntry)
+	return e.id, true
+}
+
+//heap.Interface, these are for container/heap and shouldn't be called directly
+func (x *expiryIndex) Len() int {
+	return len(x.entries)
+}
+
+func (x *expiryIndex) Less(i, j int) bool {
+	return x.entries[i].expiry.Before(x.entries[j].expiry)
+}
+
+func (x *expiryIndex) Swap(i, j int) {
+	x.entries[i], x.entries[j] = x.entries[j], x.entries[i]
+	x.entries[i].index = i
+	x.entries[j].index = j
+}
+
+func (x *expiryIndex) Push(v interface{}) {
+	e := v.(*expiryEntry)
+	e.index = len(x.entries)
+	x.entries = append(x.entries, e)
+}
+
+func (x *expiryIndex) Pop() interface{} {
+	n := len(x.entries)
+	e := x.entries[n-1]
+	x.entries[n-1] = nil
+	x.entries = x.entries[:n-1]
+	delete(x.byID, e.id)
+	return e
+}
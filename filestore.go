@@ -0,0 +1,265 @@
+package session
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a session store that keeps one file per session in dir, so sessions
+//survive a restart of a single server
+type FileStore struct {
+	storeConfig
+	sweeper
+	dir string
+	//held across the version check and write in Save, and the expiry check and
+	//remove in Sweep
+	mu sync.Mutex
+}
+
+//ctor, sessions are written to dir which is created if it doesn't exist
+func NewFileStore(dir string, opts ...Option) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	fs := &FileStore{storeConfig: newStoreConfig(opts), sweeper: newSweeper(), dir: dir}
+	fs.taken = fs.has
+	go fs.Sweep()
+	return fs, nil
+}
+
+//whether there's a session file for id
+func (s *FileStore) has(id string) bool {
+	p, ok := s.path(id)
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+//the file for the session id, ids come from the cookie so anything that
+//could escape the directory is refused
+func (s *FileStore) path(id string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return "", false
+		}
+	}
+	return filepath.Join(s.dir, id), true
+}
+
+func (s *FileStore) Load(req *web.Request) *Session {
+	if sess, ok := s.LoadByID(s.loadID(req)); ok {
+		return s.loaded(req, sess)
+	}
+	return s.fresh(req)
+}
+
+//the live session stored under id, see IDLoader
+//a missing, unreadable or corrupt file is treated as no session
+func (s *FileStore) LoadByID(id string) (*Session, bool) {
+	p, ok := s.path(id)
+	if !ok {
+		return nil, false
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, false
+	}
+
+	sess, err := s.codec.Unmarshal(b)
+	if err != nil {
+		log.Printf("session: discarding unreadable session file %s: %v", p, err)
+		return nil, false
+	}
+	if s.expired(sess) {
+		return nil, false
+	}
+	return sess, true
+}
+
+//the session is written to a temp file which is then renamed over the old one,
+//so a reader never sees a partially written session
+//the version check only holds within this process, see ErrConflict
+func (s *FileStore) Save(req *web.Request, sess *Session) error {
+	p, ok := s.path(sess.id)
+	if !ok {
+		return errors.New("session: invalid session id " + sess.id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, _ := s.LoadByID(sess.id)
+	if err := s.nextVersion(current, sess); err != nil {
+		return err
+	}
+	s.stamp(sess)
+
+	b, err := s.codec.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && sess.restored {
+		//the sweep goes by the modification time
+		err = os.Chtimes(f.Name(), sess.timestamp, sess.timestamp)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *FileStore) Destroy(req *web.Request, sess *Session) {
+	if p, ok := s.path(sess.id); ok {
+		os.Remove(p)
+	}
+	s.destroyed(sess)
+}
+
+//call fn with each live session, stopping if it returns false, see Iterable
+func (s *FileStore) ForEach(fn func(id string, sess *Session) bool) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		log.Printf("session: could not list session files: %v", err)
+		return
+	}
+	for _, fi := range files {
+		//temp files from a Save in progress aren't valid ids, so they're skipped
+		sess, ok := s.LoadByID(fi.Name())
+		if ok && !fn(sess.id, sess) {
+			return
+		}
+	}
+}
+
+//delete every session file, see AllDestroyer
+func (s *FileStore) DestroyAll() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		p := filepath.Join(s.dir, fi.Name())
+		var sess *Session
+		if s.onDestroy != nil {
+			//the hook wants the session, so the file's read before it goes
+			if b, err := ioutil.ReadFile(p); err == nil {
+				sess, _ = s.codec.Unmarshal(b)
+			}
+		}
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if sess != nil {
+			s.destroyed(sess)
+		}
+	}
+	return nil
+}
+
+//whether the session file has expired, files older than the store's max age are
+//decoded to check for a longer max age given with SetMaxAge
+//the decoded session is returned too, if the file could be read
+func (s *FileStore) expiredFile(fi os.FileInfo) (bool, *Session) {
+	if !fi.ModTime().Add(s.maxAge).Before(s.now()) {
+		return false, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, fi.Name()))
+	if err != nil {
+		return true, nil
+	}
+	sess, err := s.codec.Unmarshal(b)
+	if err != nil {
+		return true, nil
+	}
+	return s.expired(sess), sess
+}
+
+//remove the session file called name if it has expired, returning whether it
+//was removed and the session it held, nil when it couldn't be read
+//the file is checked again under the store lock, so a Save landing since the
+//directory was read keeps it
+func (s *FileStore) removeExpired(name string) (bool, *Session) {
+	p := filepath.Join(s.dir, name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fi, err := os.Stat(p)
+	if err != nil {
+		return false, nil
+	}
+	expired, sess := s.expiredFile(fi)
+	if !expired || os.Remove(p) != nil {
+		return false, nil
+	}
+	return true, sess
+}
+
+//delete the session files that haven't been written in maxAge
+//Sweep runs until the store is closed
+func (s *FileStore) Sweep() {
+	tick, stopTicker := s.sweepTicker()
+	defer stopTicker()
+	for {
+		beg := time.Now()
+
+		i := 0
+		files, err := ioutil.ReadDir(s.dir)
+		if err != nil {
+			log.Printf("session: could not read session dir %s: %v", s.dir, err)
+		}
+		for _, fi := range files {
+			if fi.IsDir() {
+				continue
+			}
+			if !fi.ModTime().Add(s.maxAge).Before(s.now()) {
+				continue
+			}
+			if removed, sess := s.removeExpired(fi.Name()); removed {
+				i++
+				if sess != nil {
+					s.destroyed(sess)
+				}
+			}
+		}
+		taken := time.Since(beg)
+
+		if s.stats != nil {
+			s.stats(len(files), i, taken)
+		} else {
+			log.Printf("session file store had %d total sessions, but deleted %d sessions. took %v ms",
+				len(files), i, int64(taken/time.Millisecond))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-tick:
+		}
+	}
+}
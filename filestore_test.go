@@ -0,0 +1,106 @@
+package session
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+}
+
+func TestFileStoreCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	//as if the server died part way through writing it
+	b, err := ioutil.ReadFile(filepath.Join(dir, id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, id), b[:len(b)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.LoadByID(id); ok {
+		t.Fatal("loaded a truncated session file")
+	}
+	var isNew bool
+	serve(store, func(req *web.Request) {
+		isNew = IsNew(req)
+	}, sessionCookieName+"="+id)
+	if !isNew {
+		t.Error("truncated session file didn't give a new session")
+	}
+}
+
+func TestFileStoreRefusesPaths(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, ok := store.LoadByID("../../etc/passwd"); ok {
+		t.Error("loaded a session from outside the store's directory")
+	}
+}
+
+func TestFileStoreSweepRechecks(t *testing.T) {
+	dir := t.TempDir()
+	clock := newFakeClock()
+	store, err := NewFileStore(dir, MaxAge(time.Minute), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+	//file times are the wall clock's, so line them up with the store's
+	stamp := func(at time.Time) {
+		if err := os.Chtimes(filepath.Join(dir, id), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//as the sweep saw it when it listed the directory
+	stamp(clock.Now().Add(-2 * time.Minute))
+
+	//saved again since
+	serve(store, func(req *web.Request) {
+		Set(req, "user", "alice")
+	}, sessionCookieName+"="+id)
+	if removed, _ := store.removeExpired(id); removed {
+		t.Fatal("removed a session saved since the directory was read")
+	}
+	if _, ok := store.LoadByID(id); !ok {
+		t.Fatal("session gone after the sweep")
+	}
+
+	stamp(clock.Now())
+	clock.advance(2 * time.Minute)
+	if removed, sess := store.removeExpired(id); !removed || sess == nil || sess.id != id {
+		t.Fatalf("expired session not removed: %v, %v", removed, sess)
+	}
+	if _, err := os.Stat(filepath.Join(dir, id)); !os.IsNotExist(err) {
+		t.Errorf("session file still there: %v", err)
+	}
+}
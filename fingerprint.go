@@ -0,0 +1,33 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"github.com/garyburd/twister/web"
+)
+
+//a fingerprint for Bind, a hash of the request's User-Agent header
+//browsers update themselves, which changes the header and logs the user out
+func UserAgent(req *web.Request) string {
+	sum := sha256.Sum256([]byte(req.Header.Get(web.HeaderUserAgent)))
+	return hex.EncodeToString(sum[:])
+}
+
+//a fingerprint for Bind, the client's network: the /24 of an IPv4 address or
+//the /64 of an IPv6 one, so moving between addresses on the same network is allowed
+//behind a proxy RemoteAddr is the proxy's address, and every client looks the same
+func IPNetwork(req *web.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
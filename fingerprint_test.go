@@ -0,0 +1,54 @@
+package session
+
+import (
+	"testing"
+	"github.com/garyburd/twister/web"
+)
+
+//serve a request from the given User-Agent, returning the session it ended up with
+func serveAs(store SessionManager, ua string, fn func(req *web.Request), cookies ...string) *recorder {
+	req, rec := newRequest(cookies...)
+	req.Header.Set(web.HeaderUserAgent, ua)
+	serveWith(store, Config{}, req, fn)
+	return rec
+}
+
+func TestBindUserAgent(t *testing.T) {
+	store := MemoryStoreNoSweep(Bind(UserAgent))
+	rec := serveAs(store, "Firefox/10", func(req *web.Request) {
+		Set(req, "user", "bob")
+	})
+	id := cookieValue(rec, sessionCookieName)
+
+	var user string
+	serveAs(store, "Firefox/10", func(req *web.Request) {
+		Get(req, "user", &user)
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("same User-Agent loaded user %q, want bob", user)
+	}
+
+	user = ""
+	var isNew bool
+	serveAs(store, "curl/7.21", func(req *web.Request) {
+		Get(req, "user", &user)
+		isNew = IsNew(req)
+	}, sessionCookieName+"="+id)
+	if user != "" || !isNew {
+		t.Error("session loaded by a different User-Agent")
+	}
+}
+
+func TestIPNetwork(t *testing.T) {
+	for _, c := range []struct{ a, b string; same bool }{
+		{"10.0.0.1:1234", "10.0.0.200:80", true},
+		{"10.0.0.1:1234", "10.0.1.1:1234", false},
+		{"[2001:db8::1]:80", "[2001:db8::ffff]:80", true},
+		{"[2001:db8::1]:80", "[2001:db8:0:1::1]:80", false},
+	} {
+		ra, rb := &web.Request{RemoteAddr: c.a}, &web.Request{RemoteAddr: c.b}
+		if same := IPNetwork(ra) == IPNetwork(rb); same != c.same {
+			t.Errorf("%s and %s on the same network: %v, want %v", c.a, c.b, same, c.same)
+		}
+	}
+}
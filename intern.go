@@ -0,0 +1,25 @@
+package session
+
+import (
+	"unique"
+)
+
+//string values longer than this aren't interned, long values are seldom shared by
+//many sessions, and one off values like tokens would only fill the table
+const maxInternLength = 64
+
+//swap the session's short string values for the copies shared through the unique
+//package, see InternStrings, the session must be write locked
+//the session holds on to the handles, so a value stays shared as long as a session
+//has it, and is dropped from the table once none do
+func (sess *Session) intern() {
+	handles := sess.interned[:0]
+	for k, v := range sess.data {
+		if s, ok := v.(string); ok && len(s) <= maxInternLength {
+			h := unique.Make(s)
+			sess.data[k] = h.Value()
+			handles = append(handles, h)
+		}
+	}
+	sess.interned = handles
+}
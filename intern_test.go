@@ -0,0 +1,67 @@
+package session
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+//a memory store holding n sessions, each with its own copies of the same values
+func internStore(n int, opts ...Option) *memoryStore {
+	s := MemoryStoreNoSweep(opts...)
+	for i := 0; i < n; i++ {
+		sess := s.fresh(nil)
+		sess.id = fmt.Sprint(i)
+		for _, k := range []string{"role", "plan", "locale", "theme", "region"} {
+			sess.data[k] = strings.Repeat(k+" ", 8)
+		}
+		s.Save(nil, sess)
+	}
+	return s
+}
+
+func TestInternStrings(t *testing.T) {
+	for _, intern := range []bool{false, true} {
+		var opts []Option
+		if intern {
+			opts = append(opts, InternStrings())
+		}
+		s := internStore(2, opts...)
+		var roles []string
+		s.ForEach(func(id string, sess *Session) bool {
+			roles = append(roles, sess.Data()["role"].(string))
+			return true
+		})
+		shared := unsafe.StringData(roles[0]) == unsafe.StringData(roles[1])
+		if shared != intern {
+			t.Errorf("interning %v: sessions share their role %v", intern, shared)
+		}
+	}
+}
+
+//the heap the sessions of an internStore hold on to, per session
+func benchmarkInternHeap(b *testing.B, opts ...Option) {
+	const n = 10000
+	var per float64
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		s := internStore(n, opts...)
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		runtime.KeepAlive(s)
+		per = float64(after.HeapAlloc-before.HeapAlloc) / n
+	}
+	b.ReportMetric(per, "heap-B/session")
+}
+
+func BenchmarkSessionsInterned(b *testing.B) {
+	benchmarkInternHeap(b, InternStrings())
+}
+
+func BenchmarkSessionsNotInterned(b *testing.B) {
+	benchmarkInternHeap(b)
+}
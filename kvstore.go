@@ -0,0 +1,89 @@
+package session
+
+import (
+	"log"
+)
+
+//the reads and writes of a store keeping each session encoded under its id in a
+//backend that expires entries itself, redis or dynamo say, the storeConfig
+//methods below do the rest
+type kvBackend interface {
+	//the encoded session stored under id, a nil b and no error when there's none
+	get(id string) (kvEntry, error)
+	//store the encoded session, to be expired after ttl seconds, only if what's
+	//stored is still prev, returns ErrConflict when it isn't
+	put(sess *Session, b []byte, ttl int64, prev kvEntry) error
+}
+
+//what a backend held for a session when it was read
+type kvEntry struct {
+	//the encoded session, nil when there was none
+	b []byte
+	//the backend's marker for this copy, which its conditional write compares:
+	//a memcache cas id, an etcd mod revision, or the version stored beside the
+	//data, 0 when there was nothing stored, backends comparing b leave it 0
+	rev int64
+}
+
+//decode a session read from a backend, ErrNotFound for one that's expired, or
+//that's corrupt, as it won't decode any better on a retry
+func (c *storeConfig) decode(id string, b []byte) (*Session, error) {
+	sess, err := c.codec.Unmarshal(b)
+	if err != nil {
+		log.Printf("session: could not decode session %s: %v", id, err)
+		return nil, ErrNotFound
+	}
+	//the backend may not have got round to deleting it
+	if c.expired(sess) {
+		return nil, ErrNotFound
+	}
+	return sess, nil
+}
+
+//LoadChecked for a store over kv
+func (c *storeConfig) loadKV(kv kvBackend, id string) (*Session, error) {
+	if id == "" {
+		return nil, ErrNotFound
+	}
+	e, err := kv.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if e.b == nil {
+		return nil, ErrNotFound
+	}
+	return c.decode(id, e.b)
+}
+
+//LoadByID for a store over kv, a read that failed is logged, naming the backend
+func (c *storeConfig) loadKVByID(kv kvBackend, backend, id string) (*Session, bool) {
+	sess, err := c.loadKV(kv, id)
+	if err != nil && err != ErrNotFound {
+		log.Printf("session: %s load of %s failed: %v", backend, id, err)
+	}
+	return sess, err == nil
+}
+
+//Save for a store over kv
+//the write is conditional on the copy read here still being stored, so of two
+//saves landing together only one succeeds, the other gets ErrConflict
+func (c *storeConfig) saveKV(kv kvBackend, sess *Session) error {
+	prev, err := kv.get(sess.id)
+	if err != nil {
+		return err
+	}
+	var current *Session
+	if prev.b != nil {
+		//one that's corrupt or expired is overwritten
+		current, _ = c.decode(sess.id, prev.b)
+	}
+	if err := c.nextVersion(current, sess); err != nil {
+		return err
+	}
+	c.stamp(sess)
+	b, err := c.codec.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	return kv.put(sess, b, c.ttlSeconds(sess), prev)
+}
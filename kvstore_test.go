@@ -0,0 +1,74 @@
+package session
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//a store over a kvBackend, as the stores below are
+type kvStore interface {
+	SessionManager
+	IDLoader
+	kvBackend
+	saveKV(kv kvBackend, sess *Session) error
+}
+
+//a kvBackend whose reads wait for each other, so that the saves using it all
+//read what's stored before any of them writes
+type racingKV struct {
+	kvBackend
+	read sync.WaitGroup
+}
+
+func (r *racingKV) get(id string) (kvEntry, error) {
+	e, err := r.kvBackend.get(id)
+	r.read.Done()
+	r.read.Wait()
+	return e, err
+}
+
+//run with -race
+func TestConcurrentSave(t *testing.T) {
+	cassandra, err := NewCassandraStore(newFakeCassandra(), "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]kvStore{
+		"redis":     RedisStoreWithClient(newFakeRedis()),
+		"memcache":  MemcacheStoreWithClient(newFakeMemcache()),
+		"etcd":      NewEtcdStore(newFakeEtcd(), MaxAge(time.Hour)),
+		"mongo":     NewMongoStore(newFakeMongo()),
+		"dynamo":    NewDynamoStore(newFakeDynamo(), "sessions"),
+		"cassandra": cassandra,
+	}
+	for name, store := range stores {
+		id := seed(t, store, map[string]interface{}{"n": 0})
+		racing := &racingKV{kvBackend: store}
+		racing.read.Add(2)
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			sess, ok := store.LoadByID(id)
+			if !ok {
+				t.Fatalf("%s: seeded session doesn't load", name)
+			}
+			go func(sess *Session) {
+				errs <- store.saveKV(racing, sess)
+			}(sess)
+		}
+		var saved, conflicts int
+		for i := 0; i < 2; i++ {
+			switch err := <-errs; err {
+			case nil:
+				saved++
+			case ErrConflict:
+				conflicts++
+			default:
+				t.Errorf("%s: save failed: %v", name, err)
+			}
+		}
+		if saved != 1 || conflicts != 1 {
+			t.Errorf("%s: two saves of one copy gave %d saved and %d conflicts, want 1 of each", name, saved, conflicts)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package session
+
+import (
+	"io"
+	"log"
+	"sync"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//the LevelDB operations the LevelDBStore needs, the leveldbstore package wraps a
+//goleveldb database in this, LevelDBStoreWithDB takes any implementation (or a fake
+//in tests)
+//Get returns a nil slice and no error when the key doesn't exist
+type LevelDB interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	//call fn with each key starting with prefix and its value, stopping at fn's first error
+	//the slices are only valid during the call
+	ForEach(prefix []byte, fn func(key, value []byte) error) error
+	//delete the keys in a single write
+	DeleteBatch(keys [][]byte) error
+}
+
+//a session store in an embedded LevelDB database, LevelDB copes with a heavy write
+//load better than bolt, and sessions survive a restart
+//LevelDB locks its directory, so only one process can use the database at a time
+type LevelDBStore struct {
+	storeConfig
+	sweeper
+	db LevelDB
+	//held across the version check and write in Save, leveldb has no transactions
+	mu sync.Mutex
+}
+
+//ctor for a LevelDBStore using db, see the leveldbstore package for opening a
+//database directory, sessions are kept under keys starting "session:" so the
+//database can hold other things too
+func LevelDBStoreWithDB(db LevelDB, opts ...Option) *LevelDBStore {
+	s := &LevelDBStore{storeConfig: newStoreConfig(opts), sweeper: newSweeper(), db: db}
+	s.taken = s.has
+	go s.Sweep()
+	return s
+}
+
+//stop the sweep loop, and close the database if it's an io.Closer, as the
+//databases the leveldbstore package opens itself are
+func (s *LevelDBStore) Close() error {
+	s.sweeper.Close()
+	if c, ok := s.db.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+//whether a session is stored under id
+func (s *LevelDBStore) has(id string) bool {
+	b, err := s.db.Get([]byte(sessionKey(id)))
+	return err == nil && b != nil
+}
+
+func (s *LevelDBStore) Load(req *web.Request) *Session {
+	if sess, ok := s.LoadByID(s.loadID(req)); ok {
+		return s.loaded(req, sess)
+	}
+	return s.fresh(req)
+}
+
+//the live session stored under id, see IDLoader
+func (s *LevelDBStore) LoadByID(id string) (*Session, bool) {
+	if id == "" {
+		return nil, false
+	}
+
+	b, err := s.db.Get([]byte(sessionKey(id)))
+	if err != nil {
+		log.Printf("session: leveldb load of %s failed: %v", id, err)
+		return nil, false
+	}
+	if b == nil {
+		return nil, false
+	}
+
+	sess, err := s.decode(id, b)
+	return sess, err == nil
+}
+
+func (s *LevelDBStore) Save(req *web.Request, sess *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.db.Get([]byte(sessionKey(sess.id)))
+	if err != nil {
+		return err
+	}
+	var current *Session
+	if b != nil {
+		//one that's corrupt or expired is overwritten
+		current, _ = s.decode(sess.id, b)
+	}
+	if err := s.nextVersion(current, sess); err != nil {
+		return err
+	}
+	s.stamp(sess)
+	if b, err = s.codec.Marshal(sess); err != nil {
+		return err
+	}
+	return s.db.Put([]byte(sessionKey(sess.id)), b)
+}
+
+func (s *LevelDBStore) Destroy(req *web.Request, sess *Session) {
+	if err := s.db.Delete([]byte(sessionKey(sess.id))); err != nil {
+		log.Printf("session: leveldb delete of %s failed: %v", sess.id, err)
+	}
+	s.destroyed(sess)
+}
+
+//call fn with each live session, stopping if it returns false, see Iterable
+func (s *LevelDBStore) ForEach(fn func(id string, sess *Session) bool) {
+	err := s.db.ForEach([]byte(sessionKey("")), func(k, v []byte) error {
+		sess, err := s.codec.Unmarshal(v)
+		if err != nil || s.expired(sess) {
+			return nil
+		}
+		if !fn(sess.id, sess) {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		log.Printf("session: leveldb iteration failed: %v", err)
+	}
+}
+
+//delete the expired sessions
+//Sweep runs until the store is closed
+func (s *LevelDBStore) Sweep() {
+	tick, stopTicker := s.sweepTicker()
+	defer stopTicker()
+	for {
+		beg := time.Now()
+
+		l, i, err := s.sweep()
+		if err != nil {
+			log.Printf("session: leveldb sweep failed: %v", err)
+		} else if taken := time.Since(beg); s.stats != nil {
+			s.stats(l, i, taken)
+		} else {
+			log.Printf("session leveldb store had %d total sessions, but deleted %d sessions. took %v ms",
+				l, i, int64(taken/time.Millisecond))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-tick:
+		}
+	}
+}
+
+//a single sweep pass, the expired sessions are deleted in one batch
+//returns the number of sessions there were and the number deleted
+//entries that can't be decoded are deleted too
+func (s *LevelDBStore) sweep() (int, int, error) {
+	var sessions []*Session
+	var expired [][]byte
+	l := 0
+	err := s.db.ForEach([]byte(sessionKey("")), func(k, v []byte) error {
+		l++
+		sess, err := s.codec.Unmarshal(v)
+		if err != nil {
+			expired = append(expired, append([]byte(nil), k...))
+		} else if s.expired(sess) {
+			expired = append(expired, append([]byte(nil), k...))
+			sessions = append(sessions, sess)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(expired) == 0 {
+		return l, 0, nil
+	}
+
+	//they're checked again under the store lock, so a Save landing since they
+	//were read keeps its session
+	s.mu.Lock()
+	still := expired[:0]
+	for _, k := range expired {
+		v, err := s.db.Get(k)
+		if err != nil {
+			s.mu.Unlock()
+			return 0, 0, err
+		}
+		if sess, err := s.codec.Unmarshal(v); v != nil && (err != nil || s.expired(sess)) {
+			still = append(still, k)
+		}
+	}
+	if len(still) > 0 {
+		if err := s.db.DeleteBatch(still); err != nil {
+			s.mu.Unlock()
+			return 0, 0, err
+		}
+	}
+	s.mu.Unlock()
+
+	deleted := make(map[string]bool, len(still))
+	for _, k := range still {
+		deleted[string(k)] = true
+	}
+	for _, sess := range sessions {
+		if deleted[sessionKey(sess.id)] {
+			s.destroyed(sess)
+		}
+	}
+	return l, len(still), nil
+}
@@ -0,0 +1,75 @@
+//goleveldb databases for the session package's LevelDBStore, kept in a package of
+//their own so only programs using LevelDB depend on it
+//	store, err := leveldbstore.New("sessions", session.MaxAge(time.Hour))
+package leveldbstore
+
+import (
+	"github.com/nstott/session"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+//ctor, opens (or creates) the LevelDB database in the directory path, it's closed
+//along with the store
+func New(path string, opts ...session.Option) (*session.LevelDBStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return session.LevelDBStoreWithDB(ownedDB{levelDB{db}}, opts...), nil
+}
+
+//ctor for a LevelDBStore using an already open database, which is left open when
+//the store is closed
+func WithDB(db *leveldb.DB, opts ...session.Option) *session.LevelDBStore {
+	return session.LevelDBStoreWithDB(levelDB{db}, opts...)
+}
+
+//session.LevelDB over goleveldb
+type levelDB struct {
+	db *leveldb.DB
+}
+
+func (l levelDB) Get(key []byte) ([]byte, error) {
+	b, err := l.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (l levelDB) Put(key, value []byte) error {
+	return l.db.Put(key, value, nil)
+}
+
+func (l levelDB) Delete(key []byte) error {
+	return l.db.Delete(key, nil)
+}
+
+func (l levelDB) ForEach(prefix []byte, fn func(key, value []byte) error) error {
+	it := l.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer it.Release()
+	for it.Next() {
+		if err := fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+func (l levelDB) DeleteBatch(keys [][]byte) error {
+	batch := new(leveldb.Batch)
+	for _, k := range keys {
+		batch.Delete(k)
+	}
+	return l.db.Write(batch, nil)
+}
+
+//a database New opened, the store closes it
+type ownedDB struct {
+	levelDB
+}
+
+func (d ownedDB) Close() error {
+	return d.db.Close()
+}
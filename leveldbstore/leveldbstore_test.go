@@ -0,0 +1,97 @@
+package leveldbstore
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"github.com/nstott/session"
+)
+
+//a session.Clock set by hand, the store's sweep loop reads it too
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func TestLevelDBStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions")
+	store, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, sess := session.NewTestSession(map[string]interface{}{"user": "bob"})
+	if err := store.Save(req, sess); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the session survives the database being closed and reopened
+	store, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	got, ok := store.LoadByID(sess.ID())
+	if !ok {
+		t.Fatal("saved session doesn't load after reopening the database")
+	}
+	if user, _ := got.Data()["user"].(string); user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+
+	store.Destroy(req, got)
+	if _, ok := store.LoadByID(sess.ID()); ok {
+		t.Error("destroyed session loaded")
+	}
+}
+
+func TestLevelDBStoreSweep(t *testing.T) {
+	c := &clock{t: time.Now()}
+	swept := make(chan int, 10)
+	store, err := New(filepath.Join(t.TempDir(), "sessions"), session.MaxAge(time.Minute),
+		session.WithClock(c), session.SweepInterval(10*time.Millisecond),
+		session.WithStats(func(total, deleted int, took time.Duration) {
+			swept <- deleted
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	req, sess := session.NewTestSession(map[string]interface{}{"user": "bob"})
+	if err := store.Save(req, sess); err != nil {
+		t.Fatal(err)
+	}
+	c.advance(2 * time.Minute)
+
+	timeout := time.After(5 * time.Second)
+	for deleted := 0; deleted == 0; {
+		select {
+		case deleted = <-swept:
+		case <-timeout:
+			t.Fatal("expired session never swept")
+		}
+	}
+	n := 0
+	store.ForEach(func(id string, sess *session.Session) bool {
+		n++
+		return true
+	})
+	if n != 0 {
+		t.Errorf("%d sessions left after the sweep, want 0", n)
+	}
+}
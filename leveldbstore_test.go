@@ -0,0 +1,178 @@
+package session
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a LevelDB keeping the keys in a map, the store's sweep loop uses it too
+type fakeLevelDB struct {
+	mu      sync.Mutex
+	keys    map[string][]byte
+	batches int
+}
+
+func newFakeLevelDB() *fakeLevelDB {
+	return &fakeLevelDB{keys: make(map[string][]byte)}
+}
+
+func (db *fakeLevelDB) Get(key []byte) ([]byte, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.keys[string(key)], nil
+}
+
+func (db *fakeLevelDB) Put(key, value []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.keys[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (db *fakeLevelDB) Delete(key []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.keys, string(key))
+	return nil
+}
+
+func (db *fakeLevelDB) ForEach(prefix []byte, fn func(key, value []byte) error) error {
+	db.mu.Lock()
+	var keys []string
+	for k := range db.keys {
+		if bytes.HasPrefix([]byte(k), prefix) {
+			keys = append(keys, k)
+		}
+	}
+	db.mu.Unlock()
+	sort.Strings(keys)
+	for _, k := range keys {
+		v, _ := db.Get([]byte(k))
+		if v == nil {
+			continue
+		}
+		if err := fn([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *fakeLevelDB) DeleteBatch(keys [][]byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, k := range keys {
+		delete(db.keys, string(k))
+	}
+	db.batches++
+	return nil
+}
+
+func (db *fakeLevelDB) has(key string) bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	_, ok := db.keys[key]
+	return ok
+}
+
+func TestLevelDBStore(t *testing.T) {
+	store := LevelDBStoreWithDB(newFakeLevelDB())
+	defer store.Close()
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+		Set(req, "user", "alice")
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+	sess, ok := store.LoadByID(id)
+	if !ok || sess.Data()["user"] != "alice" {
+		t.Fatal("update not saved")
+	}
+
+	serve(store, func(req *web.Request) {
+		Destroy(req)
+	}, sessionCookieName+"="+id)
+	if _, ok := store.LoadByID(id); ok {
+		t.Error("destroyed session loaded")
+	}
+}
+
+//run with -race
+func TestLevelDBStoreConcurrentSave(t *testing.T) {
+	store := LevelDBStoreWithDB(newFakeLevelDB())
+	defer store.Close()
+	id := seed(t, store, map[string]interface{}{"n": 0})
+
+	//copies of one version saved together, only the first to land is stored
+	const copies = 8
+	errs := make(chan error, copies)
+	for i := 0; i < copies; i++ {
+		sess, ok := store.LoadByID(id)
+		if !ok {
+			t.Fatal("seeded session doesn't load")
+		}
+		go func(sess *Session) {
+			errs <- store.Save(&web.Request{Env: make(map[string]interface{})}, sess)
+		}(sess)
+	}
+	var saved, conflicts int
+	for i := 0; i < copies; i++ {
+		switch err := <-errs; err {
+		case nil:
+			saved++
+		case ErrConflict:
+			conflicts++
+		default:
+			t.Errorf("save failed: %v", err)
+		}
+	}
+	if saved != 1 || conflicts != copies-1 {
+		t.Errorf("%d saves of one copy gave %d saved and %d conflicts, want 1 and %d", copies, saved, conflicts, copies-1)
+	}
+}
+
+func TestLevelDBStoreSweep(t *testing.T) {
+	db := newFakeLevelDB()
+	clock := newFakeClock()
+	store := LevelDBStoreWithDB(db, MaxAge(10*time.Minute), WithClock(clock), SweepInterval(time.Hour))
+	defer store.Close()
+	var old []string
+	for i := 0; i < 3; i++ {
+		old = append(old, seed(t, store, map[string]interface{}{"n": i}))
+	}
+	clock.advance(6 * time.Minute)
+	live := seed(t, store, map[string]interface{}{"n": 3})
+	clock.advance(6 * time.Minute)
+
+	db.mu.Lock()
+	batches := db.batches
+	db.mu.Unlock()
+	total, deleted, err := store.sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 || deleted != 3 {
+		t.Errorf("sweep saw %d sessions and deleted %d, want 4 and 3", total, deleted)
+	}
+	db.mu.Lock()
+	if db.batches != batches+1 {
+		t.Errorf("sweep made %d delete batches, want 1", db.batches-batches)
+	}
+	db.mu.Unlock()
+	for _, id := range old {
+		if db.has(sessionKey(id)) {
+			t.Errorf("expired session %s not deleted", id)
+		}
+	}
+	if !db.has(sessionKey(live)) {
+		t.Error("live session deleted")
+	}
+}
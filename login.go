@@ -0,0 +1,176 @@
+package session
+
+import (
+	"fmt"
+	"sync"
+	"github.com/garyburd/twister/web"
+)
+
+//the logged in user's id is kept in the session data under this key
+const userKey = "_user"
+
+//what Login does when a user already has Config.MaxSessionsPerUser sessions
+type SessionLimitPolicy int
+
+const (
+	//destroy the user's oldest session to make room, logging that device out
+	EvictOldest SessionLimitPolicy = iota
+	//refuse the new login, Login returns false
+	RefuseLogin
+)
+
+//record that the request's user has logged in as userID
+//the session is given a new id first, so an id planted on the user before
+//they logged in (session fixation) is useless to an attacker
+//returns false if there's no session, it couldn't be changed, or the user is at
+//the handler's MaxSessionsPerUser with the RefuseLogin policy
+func Login(req *web.Request, userID interface{}) bool {
+	h, ok := handlerFor(req)
+	limited := ok && h.users != nil
+	user := fmt.Sprint(userID)
+	if limited && h.config.SessionLimitPolicy == RefuseLogin {
+		//logging in again on a session the user already has takes no more room
+		n, current := 0, ID(req)
+		for _, id := range h.liveSessions(user) {
+			if id != current {
+				n++
+			}
+		}
+		if n >= h.config.MaxSessionsPerUser {
+			return false
+		}
+	}
+
+	if Regenerate(req) == "" || !Set(req, userKey, userID) {
+		return false
+	}
+
+	if limited {
+		//expired sessions shouldn't push out live ones
+		h.liveSessions(user)
+		for _, id := range h.users.add(user, ID(req), h.config.MaxSessionsPerUser) {
+			h.destroyID(req, id)
+		}
+	}
+	return true
+}
+
+//the id of the logged in user, set with Login
+func UserID(req *web.Request) (interface{}, bool) {
+	return value(req, userKey)
+}
+
+//log the user out, the session is destroyed along with everything in it
+func Logout(req *web.Request) {
+	Destroy(req)
+}
+
+//the user's sessions that are still in the store, oldest first
+//the ones that have expired are forgotten, when the store can say
+func (h *sessionHandler) liveSessions(user string) []string {
+	ids := h.users.list(user)
+	loader, ok := h.manager.(IDLoader)
+	if !ok {
+		return ids
+	}
+	live := ids[:0]
+	for _, id := range ids {
+		if _, ok := loader.LoadByID(id); ok {
+			live = append(live, id)
+		} else {
+			h.users.remove(id)
+		}
+	}
+	return live
+}
+
+//destroy the session stored under id, it's loaded first when the store can,
+//so the OnDestroy hook sees its data
+func (h *sessionHandler) destroyID(req *web.Request, id string) {
+	sess := &Session{id: id, data: make(map[string]interface{})}
+	if loader, ok := h.manager.(IDLoader); ok {
+		if loaded, ok := loader.LoadByID(id); ok {
+			sess = loaded
+		}
+	}
+	h.manager.Destroy(req, sess)
+	h.users.remove(id)
+}
+
+//the ids of the sessions each user is logged in to, oldest first
+type userSessions struct {
+	mu    sync.Mutex
+	ids   map[string][]string
+	users map[string]string
+}
+
+func newUserSessions() *userSessions {
+	return &userSessions{ids: make(map[string][]string), users: make(map[string]string)}
+}
+
+func (u *userSessions) list(user string) []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.ids[user]...)
+}
+
+//record the user's new session, returning the oldest sessions that take them
+//over max, which the caller destroys
+//a session that was already logged in, as this user or another, moves to the
+//end of this user's list
+func (u *userSessions) add(user, id string, max int) []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.drop(id)
+	u.ids[user] = append(u.ids[user], id)
+	u.users[id] = user
+	var evicted []string
+	if n := len(u.ids[user]) - max; n > 0 {
+		evicted = append(evicted, u.ids[user][:n]...)
+	}
+	return evicted
+}
+
+func (u *userSessions) remove(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.drop(id)
+}
+
+//forget the session, u must be locked
+func (u *userSessions) drop(id string) {
+	user, ok := u.users[id]
+	if !ok {
+		return
+	}
+	delete(u.users, id)
+	ids := u.ids[user]
+	for i, v := range ids {
+		if v == id {
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(u.ids, user)
+	} else {
+		u.ids[user] = ids
+	}
+}
+
+//a logged in session was given a new id by Regenerate, it keeps its place
+func (u *userSessions) rename(old, id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	user, ok := u.users[old]
+	if !ok {
+		return
+	}
+	delete(u.users, old)
+	u.users[id] = user
+	for i, v := range u.ids[user] {
+		if v == old {
+			u.ids[user][i] = id
+		}
+	}
+}
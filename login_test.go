@@ -0,0 +1,127 @@
+package session
+
+import (
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+func TestLogin(t *testing.T) {
+	store := MemoryStoreNoSweep()
+	id := seed(t, store, map[string]interface{}{"cart": 2})
+
+	req, rec := newRequest(sessionCookieName + "=" + id)
+	serveWith(store, Config{}, req, func(req *web.Request) {
+		if !Login(req, "bob") {
+			t.Fatal("Login failed")
+		}
+	})
+	newID := cookieValue(rec, sessionCookieName)
+	if newID == "" || newID == id {
+		t.Fatalf("Login left the session id as %q", newID)
+	}
+	if _, ok := store.LoadByID(id); ok {
+		t.Error("session is still under its id from before Login")
+	}
+
+	var user interface{}
+	var cart int
+	serve(store, func(req *web.Request) {
+		user, _ = UserID(req)
+		cart, _ = GetInt(req, "cart")
+		Logout(req)
+	}, sessionCookieName+"="+newID)
+	if user != "bob" || cart != 2 {
+		t.Errorf("logged in session has user %v and cart %d, want bob and 2", user, cart)
+	}
+	if _, ok := store.LoadByID(newID); ok {
+		t.Error("session still in the store after Logout")
+	}
+}
+
+func TestMaxSessionsPerUser(t *testing.T) {
+	store := MemoryStoreNoSweep()
+	config := Config{MaxSessionsPerUser: 2}
+	handler := NewSessionHandler(store, web.HandlerFunc(func(req *web.Request) {
+		Login(req, "bob")
+		req.Respond(web.StatusOK)
+	}), config)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		req, rec := newRequest()
+		handler.ServeWeb(req)
+		ids = append(ids, cookieValue(rec, sessionCookieName))
+	}
+	if _, ok := store.LoadByID(ids[0]); ok {
+		t.Error("oldest session kept past MaxSessionsPerUser")
+	}
+	for _, id := range ids[1:] {
+		if _, ok := store.LoadByID(id); !ok {
+			t.Errorf("session %s evicted, only the oldest should be", id)
+		}
+	}
+
+	var ok []bool
+	handler = NewSessionHandler(store, web.HandlerFunc(func(req *web.Request) {
+		ok = append(ok, Login(req, "alice"))
+		req.Respond(web.StatusOK)
+	}), Config{MaxSessionsPerUser: 1, SessionLimitPolicy: RefuseLogin})
+	for i := 0; i < 2; i++ {
+		req, _ := newRequest()
+		handler.ServeWeb(req)
+	}
+	if !ok[0] || ok[1] {
+		t.Errorf("logins with RefuseLogin and a limit of 1 gave %v, want [true false]", ok)
+	}
+}
+
+func TestRefuseLoginSameSession(t *testing.T) {
+	store := MemoryStoreNoSweep()
+	var ok []bool
+	handler := NewSessionHandler(store, web.HandlerFunc(func(req *web.Request) {
+		ok = append(ok, Login(req, "alice"))
+		req.Respond(web.StatusOK)
+	}), Config{MaxSessionsPerUser: 1, SessionLimitPolicy: RefuseLogin})
+	req, rec := newRequest()
+	handler.ServeWeb(req)
+	//the same browser logging in again
+	req, _ = newRequest(sessionCookieName + "=" + cookieValue(rec, sessionCookieName))
+	handler.ServeWeb(req)
+	if !ok[0] || !ok[1] {
+		t.Errorf("logins on one session with RefuseLogin and a limit of 1 gave %v, want [true true]", ok)
+	}
+}
+
+func TestUserSessionsPruned(t *testing.T) {
+	clock := newFakeClock()
+	store := MemoryStoreNoSweep(MaxAge(10*time.Minute), WithClock(clock))
+	handler := NewSessionHandler(store, web.HandlerFunc(func(req *web.Request) {
+		Login(req, req.Param.Get("user"))
+		req.Respond(web.StatusOK)
+	}), Config{MaxSessionsPerUser: 2}).(*sessionHandler)
+	login := func(user string) string {
+		req, rec := newRequest()
+		req.Param.Set("user", user)
+		handler.ServeWeb(req)
+		return cookieValue(rec, sessionCookieName)
+	}
+	bob := login("bob")
+	login("alice")
+	if n := len(handler.users.users); n != 2 {
+		t.Fatalf("%d sessions indexed, want 2", n)
+	}
+
+	//destroyed in the store, not through the handler
+	sess, _ := store.LoadByID(bob)
+	store.Destroy(nil, sess)
+	if ids := handler.users.list("bob"); len(ids) != 0 {
+		t.Errorf("bob's destroyed session still indexed: %q", ids)
+	}
+
+	//neither user logs in again, the sweep alone forgets alice's session
+	clock.advance(11 * time.Minute)
+	store.sweep(clock.Now())
+	if len(handler.users.ids) != 0 || len(handler.users.users) != 0 {
+		t.Errorf("swept sessions still indexed: %v", handler.users.ids)
+	}
+}
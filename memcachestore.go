@@ -0,0 +1,264 @@
+package session
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"github.com/garyburd/twister/web"
+)
+
+//the memcached commands the MemcacheStore needs, this lets the store run against
+//any client (or a fake in tests)
+//Gets returns the item with its cas id, a nil slice and no error on a cache miss
+//CompareAndSwap stores the item only while its cas id is still cas, adding it
+//when cas is 0, and returns ErrConflict when another write got there first
+type MemcacheClient interface {
+	Gets(key string) ([]byte, uint64, error)
+	CompareAndSwap(key string, value []byte, expiration int, cas uint64) error
+	Delete(key string) error
+}
+
+//a memcached backed session store
+//memcached may evict sessions before they expire when it runs short of memory
+type MemcacheStore struct {
+	storeConfig
+	client MemcacheClient
+}
+
+//ctor, keys are spread over the servers by hash
+func NewMemcacheStore(servers []string, opts ...Option) *MemcacheStore {
+	c := &memcacheConn{}
+	for _, addr := range servers {
+		c.servers = append(c.servers, &memcacheServer{addr: addr})
+	}
+	return MemcacheStoreWithClient(c, opts...)
+}
+
+//ctor for a MemcacheStore using an already configured client
+func MemcacheStoreWithClient(client MemcacheClient, opts ...Option) *MemcacheStore {
+	return &MemcacheStore{storeConfig: newStoreConfig(opts), client: client}
+}
+
+//a backend that can't be read is handled as the store's FailureMode says
+func (s *MemcacheStore) Load(req *web.Request) *Session {
+	return s.loadChecked(req, s)
+}
+
+//the live session stored under id, see IDLoader
+func (s *MemcacheStore) LoadByID(id string) (*Session, bool) {
+	return s.loadKVByID(s, "memcache", id)
+}
+
+//LoadByID, with the error when the backend couldn't be read, see CheckedLoader
+func (s *MemcacheStore) LoadChecked(id string) (*Session, error) {
+	return s.loadKV(s, id)
+}
+
+func (s *MemcacheStore) Save(req *web.Request, sess *Session) error {
+	return s.saveKV(s, sess)
+}
+
+//the encoded session under id, with its cas id, see kvBackend
+func (s *MemcacheStore) get(id string) (kvEntry, error) {
+	b, cas, err := s.client.Gets(sessionKey(id))
+	return kvEntry{b: b, rev: int64(cas)}, err
+}
+
+func (s *MemcacheStore) put(sess *Session, b []byte, ttl int64, prev kvEntry) error {
+	if ttl > memcacheMaxTTL {
+		ttl += sess.timestamp.Unix()
+	}
+	return s.client.CompareAndSwap(sessionKey(sess.id), b, int(ttl), uint64(prev.rev))
+}
+
+//the longest expiration memcached takes as a number of seconds, anything longer
+//is taken as a unix time
+const memcacheMaxTTL = 30 * 24 * 60 * 60
+
+func (s *MemcacheStore) Destroy(req *web.Request, sess *Session) {
+	if err := s.client.Delete(sessionKey(sess.id)); err != nil {
+		log.Printf("session: memcache delete of %s failed: %v", sess.id, err)
+	}
+	s.destroyed(sess)
+}
+
+//memcached expires the items itself, so there's nothing to sweep
+func (s *MemcacheStore) Sweep() {}
+
+//a minimal memcached text protocol client
+type memcacheConn struct {
+	servers []*memcacheServer
+}
+
+//a connection to one memcached server, dialed lazily and redialed after any error
+type memcacheServer struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+var errMemcacheKey = errors.New("memcache: invalid key")
+
+//keys go on the command line, so anything that could break the protocol is refused
+func validMemcacheKey(key string) bool {
+	if len(key) == 0 || len(key) > 250 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *memcacheConn) server(key string) (*memcacheServer, error) {
+	if len(c.servers) == 0 {
+		return nil, errors.New("memcache: no servers configured")
+	}
+	if !validMemcacheKey(key) {
+		return nil, errMemcacheKey
+	}
+	return c.servers[crc32.ChecksumIEEE([]byte(key))%uint32(len(c.servers))], nil
+}
+
+func (c *memcacheConn) Gets(key string) ([]byte, uint64, error) {
+	srv, err := c.server(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	var value []byte
+	var cas uint64
+	err = srv.do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "gets %s\r\n", key)
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		for {
+			line, err := readMemcacheLine(rw.Reader)
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+			//VALUE <key> <flags> <bytes> <cas unique>
+			f := strings.Fields(line)
+			if len(f) < 5 || f[0] != "VALUE" {
+				return errors.New("memcache: unexpected reply " + line)
+			}
+			n, err := strconv.Atoi(f[3])
+			if err != nil {
+				return err
+			}
+			if cas, err = strconv.ParseUint(f[4], 10, 64); err != nil {
+				return err
+			}
+			b := make([]byte, n+2)
+			if _, err := io.ReadFull(rw, b); err != nil {
+				return err
+			}
+			value = b[:n]
+		}
+	})
+	return value, cas, err
+}
+
+//cas 0 adds the item, which fails if it's there already
+func (c *memcacheConn) CompareAndSwap(key string, value []byte, expiration int, cas uint64) error {
+	srv, err := c.server(key)
+	if err != nil {
+		return err
+	}
+	conflict := false
+	err = srv.do(func(rw *bufio.ReadWriter) error {
+		if cas == 0 {
+			fmt.Fprintf(rw, "add %s 0 %d %d\r\n", key, expiration, len(value))
+		} else {
+			fmt.Fprintf(rw, "cas %s 0 %d %d %d\r\n", key, expiration, len(value), cas)
+		}
+		rw.Write(value)
+		rw.WriteString("\r\n")
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcacheLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "STORED":
+			return nil
+		case "EXISTS", "NOT_STORED", "NOT_FOUND":
+			//changed, added or deleted since it was read, the connection's fine
+			conflict = true
+			return nil
+		}
+		return errors.New("memcache: " + line)
+	})
+	if err == nil && conflict {
+		return ErrConflict
+	}
+	return err
+}
+
+func (c *memcacheConn) Delete(key string) error {
+	srv, err := c.server(key)
+	if err != nil {
+		return err
+	}
+	return srv.do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "delete %s\r\n", key)
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readMemcacheLine(rw.Reader)
+		if err != nil {
+			return err
+		}
+		if line != "DELETED" && line != "NOT_FOUND" {
+			return errors.New("memcache: " + line)
+		}
+		return nil
+	})
+}
+
+//run fn against the server's connection, dropping the connection if fn fails
+//since the protocol stream can't be trusted after an error
+func (srv *memcacheServer) do(fn func(rw *bufio.ReadWriter) error) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.conn == nil {
+		conn, err := net.Dial("tcp", srv.addr)
+		if err != nil {
+			return err
+		}
+		srv.conn = conn
+		srv.r = bufio.NewReader(conn)
+	}
+
+	err := fn(bufio.NewReadWriter(srv.r, bufio.NewWriter(srv.conn)))
+	if err != nil {
+		srv.conn.Close()
+		srv.conn = nil
+	}
+	return err
+}
+
+func readMemcacheLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,87 @@
+package session
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a MemcacheClient keeping the items in a map, with the expiration each was last
+//set with and its cas id
+type fakeMemcache struct {
+	mu    sync.Mutex
+	items map[string][]byte
+	exps  map[string]int
+	cas   map[string]uint64
+	next  uint64
+}
+
+func newFakeMemcache() *fakeMemcache {
+	return &fakeMemcache{items: make(map[string][]byte), exps: make(map[string]int), cas: make(map[string]uint64)}
+}
+
+func (m *fakeMemcache) Gets(key string) ([]byte, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.items[key], m.cas[key], nil
+}
+
+func (m *fakeMemcache) CompareAndSwap(key string, value []byte, expiration int, cas uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cas[key] != cas {
+		return ErrConflict
+	}
+	m.next++
+	m.items[key] = append([]byte(nil), value...)
+	m.exps[key] = expiration
+	m.cas[key] = m.next
+	return nil
+}
+
+func (m *fakeMemcache) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, key)
+	delete(m.exps, key)
+	delete(m.cas, key)
+	return nil
+}
+
+func TestMemcacheStore(t *testing.T) {
+	client := newFakeMemcache()
+	store := MemcacheStoreWithClient(client)
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	if exp := client.exps[sessionKey(id)]; exp != int(sessionValidDuration.Seconds()) {
+		t.Errorf("saved with an expiration of %d, want %v", exp, sessionValidDuration.Seconds())
+	}
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+		Destroy(req)
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+	if _, ok := client.items[sessionKey(id)]; ok {
+		t.Error("destroyed session still in memcached")
+	}
+	if _, ok := store.LoadByID(id); ok {
+		t.Error("destroyed session loaded")
+	}
+}
+
+func TestMemcacheLongTTL(t *testing.T) {
+	client := newFakeMemcache()
+	clock := newFakeClock()
+	store := MemcacheStoreWithClient(client, MaxAge(60*24*time.Hour), WithClock(clock))
+	id := seed(t, store, map[string]interface{}{"a": 1})
+
+	//memcached takes anything over 30 days as a unix time
+	want := clock.Now().Add(60 * 24 * time.Hour).Unix()
+	if exp := client.exps[sessionKey(id)]; int64(exp) != want {
+		t.Errorf("saved with an expiration of %d, want the unix time %d", exp, want)
+	}
+}
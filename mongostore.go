@@ -0,0 +1,82 @@
+package session
+
+import (
+	"log"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//the MongoDB operations the MongoStore needs, see the mongostore package for these
+//over the mongo driver, tests can use a fake
+//documents look like {_id: <session id>, data: <encoded session>, updatedAt: <time>,
+//version: <number>}
+//FindByID returns the document's version with its data, a nil slice and no error
+//when there's no document
+//Upsert only writes while the document's version is still prev, 0 for a document
+//that mustn't exist, and returns ErrConflict when it isn't
+type MongoCollection interface {
+	FindByID(id string) ([]byte, int64, error)
+	Upsert(id string, data []byte, updatedAt time.Time, version, prev int64) error
+	DeleteByID(id string) error
+	//create a TTL index on field, so mongo deletes documents expireAfter past it
+	EnsureTTLIndex(field string, expireAfter time.Duration) error
+}
+
+//a MongoDB backed session store, mongo deletes the expired documents through
+//the TTL index made by EnsureIndex
+type MongoStore struct {
+	storeConfig
+	coll MongoCollection
+}
+
+//ctor, sessions are kept in coll, mongostore.New makes one over a driver collection
+func NewMongoStore(coll MongoCollection, opts ...Option) *MongoStore {
+	return &MongoStore{storeConfig: newStoreConfig(opts), coll: coll}
+}
+
+//create the TTL index expiring sessions the store's max age after they're saved
+//mongo only knows the one lifetime, so a session given a longer one with SetMaxAge
+//is deleted at the store's max age all the same
+func (s *MongoStore) EnsureIndex() error {
+	return s.coll.EnsureTTLIndex("updatedAt", s.maxAge)
+}
+
+//a backend that can't be read is handled as the store's FailureMode says
+func (s *MongoStore) Load(req *web.Request) *Session {
+	return s.loadChecked(req, s)
+}
+
+//the live session stored under id, see IDLoader
+func (s *MongoStore) LoadByID(id string) (*Session, bool) {
+	return s.loadKVByID(s, "mongo", id)
+}
+
+//LoadByID, with the error when the backend couldn't be read, see CheckedLoader
+func (s *MongoStore) LoadChecked(id string) (*Session, error) {
+	return s.loadKV(s, id)
+}
+
+func (s *MongoStore) Save(req *web.Request, sess *Session) error {
+	return s.saveKV(s, sess)
+}
+
+//the encoded session under id, with its version, see kvBackend
+func (s *MongoStore) get(id string) (kvEntry, error) {
+	b, version, err := s.coll.FindByID(id)
+	return kvEntry{b: b, rev: version}, err
+}
+
+//the TTL index works from updatedAt, so the ttl isn't needed
+func (s *MongoStore) put(sess *Session, b []byte, ttl int64, prev kvEntry) error {
+	return s.coll.Upsert(sess.id, b, sess.timestamp.UTC(), sess.version, prev.rev)
+}
+
+func (s *MongoStore) Destroy(req *web.Request, sess *Session) {
+	if err := s.coll.DeleteByID(sess.id); err != nil {
+		log.Printf("session: mongo delete of %s failed: %v", sess.id, err)
+	}
+	s.destroyed(sess)
+}
+
+//the TTL index expires the documents, so there's nothing to sweep
+func (s *MongoStore) Sweep() {}
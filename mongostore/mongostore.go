@@ -0,0 +1,108 @@
+//MongoDB collections for the session package's MongoStore, through the v2 driver,
+//kept in a package of their own so only programs using mongo depend on the driver
+//	client, err := mongo.Connect(options.Client().ApplyURI("mongodb://localhost:27017"))
+//	store := mongostore.New(client.Database("app").Collection("sessions"), session.MaxAge(time.Hour))
+//	err = store.EnsureIndex()
+package mongostore
+
+import (
+	"context"
+	"time"
+	"github.com/nstott/session"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+//how long each call to mongo gets, the store's methods don't take a context
+const timeout = 5 * time.Second
+
+//the collection calls the store makes, a *mongo.Collection has them
+type Collection interface {
+	FindOne(ctx context.Context, filter interface{}, opts ...options.Lister[options.FindOneOptions]) *mongo.SingleResult
+	UpdateOne(ctx context.Context, filter, update interface{}, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error)
+	DeleteOne(ctx context.Context, filter interface{}, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error)
+}
+
+//the index call EnsureIndex makes, a mongo.IndexView has it
+type indexCreator interface {
+	CreateOne(ctx context.Context, model mongo.IndexModel, opts ...options.Lister[options.CreateIndexesOptions]) (string, error)
+}
+
+//ctor, sessions are kept in coll
+func New(coll *mongo.Collection, opts ...session.Option) *session.MongoStore {
+	return newStore(coll, coll.Indexes(), opts)
+}
+
+func newStore(coll Collection, indexes indexCreator, opts []session.Option) *session.MongoStore {
+	return session.NewMongoStore(mongoCollection{coll, indexes}, opts...)
+}
+
+//session.MongoCollection over the driver
+type mongoCollection struct {
+	coll    Collection
+	indexes indexCreator
+}
+
+//a session's document
+type document struct {
+	ID        string    `bson:"_id"`
+	Data      []byte    `bson:"data"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	Version   int64     `bson:"version"`
+}
+
+func byID(id string) bson.D {
+	return bson.D{{Key: "_id", Value: id}}
+}
+
+func (c mongoCollection) FindByID(id string) ([]byte, int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var doc document
+	err := c.coll.FindOne(ctx, byID(id)).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc.Data, doc.Version, nil
+}
+
+//the update is filtered on the version too, when the document has moved on the
+//filter matches nothing and the upsert's insert collides with it on _id
+func (c mongoCollection) Upsert(id string, data []byte, updatedAt time.Time, version, prev int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "version", Value: prev}}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "data", Value: data},
+		{Key: "updatedAt", Value: updatedAt},
+		{Key: "version", Value: version},
+	}}}
+	_, err := c.coll.UpdateOne(ctx, filter, update, options.UpdateOne().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return session.ErrConflict
+	}
+	return err
+}
+
+func (c mongoCollection) DeleteByID(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := c.coll.DeleteOne(ctx, byID(id))
+	return err
+}
+
+//mongo checks TTL indexes about once a minute, so documents can outlive
+//expireAfter by that long, the store doesn't load them all the same
+func (c mongoCollection) EnsureTTLIndex(field string, expireAfter time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := c.indexes.CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(expireAfter / time.Second)),
+	})
+	return err
+}
@@ -0,0 +1,133 @@
+package mongostore
+
+import (
+	"context"
+	"testing"
+	"time"
+	"github.com/nstott/session"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+//a Collection keeping the documents in a map, by id, and the indexes created
+type fakeCollection struct {
+	docs    map[string]document
+	indexes []mongo.IndexModel
+}
+
+func newFakeCollection() *fakeCollection {
+	return &fakeCollection{docs: make(map[string]document)}
+}
+
+//the id a byID filter asks for
+func filterID(filter interface{}) string {
+	return filter.(bson.D)[0].Value.(string)
+}
+
+func (f *fakeCollection) FindOne(ctx context.Context, filter interface{}, opts ...options.Lister[options.FindOneOptions]) *mongo.SingleResult {
+	doc, ok := f.docs[filterID(filter)]
+	if !ok {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(doc, nil, nil)
+}
+
+func (f *fakeCollection) UpdateOne(ctx context.Context, filter, update interface{}, opts ...options.Lister[options.UpdateOneOptions]) (*mongo.UpdateResult, error) {
+	var o options.UpdateOneOptions
+	for _, l := range opts {
+		for _, set := range l.List() {
+			if err := set(&o); err != nil {
+				return nil, err
+			}
+		}
+	}
+	id := filterID(filter)
+	doc, ok := f.docs[id]
+	//a filter on the version misses a document that's moved on, and the upsert's
+	//insert then collides with it on _id
+	if ok && len(filter.(bson.D)) > 1 && filter.(bson.D)[1].Value.(int64) != doc.Version {
+		return nil, mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}
+	}
+	if !ok && (o.Upsert == nil || !*o.Upsert) {
+		return &mongo.UpdateResult{}, nil
+	}
+	doc.ID = id
+	for _, e := range update.(bson.D)[0].Value.(bson.D) {
+		switch e.Key {
+		case "data":
+			doc.Data = e.Value.([]byte)
+		case "updatedAt":
+			doc.UpdatedAt = e.Value.(time.Time)
+		case "version":
+			doc.Version = e.Value.(int64)
+		}
+	}
+	f.docs[id] = doc
+	if ok {
+		return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+	}
+	return &mongo.UpdateResult{UpsertedCount: 1, UpsertedID: id}, nil
+}
+
+func (f *fakeCollection) DeleteOne(ctx context.Context, filter interface{}, opts ...options.Lister[options.DeleteOneOptions]) (*mongo.DeleteResult, error) {
+	id := filterID(filter)
+	if _, ok := f.docs[id]; !ok {
+		return &mongo.DeleteResult{}, nil
+	}
+	delete(f.docs, id)
+	return &mongo.DeleteResult{DeletedCount: 1}, nil
+}
+
+func (f *fakeCollection) CreateOne(ctx context.Context, model mongo.IndexModel, opts ...options.Lister[options.CreateIndexesOptions]) (string, error) {
+	f.indexes = append(f.indexes, model)
+	return "updatedAt_1", nil
+}
+
+func TestMongoStore(t *testing.T) {
+	coll := newFakeCollection()
+	store := newStore(coll, coll, []session.Option{session.MaxAge(time.Hour)})
+	if err := store.EnsureIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if len(coll.indexes) != 1 {
+		t.Fatalf("%d indexes created, want 1", len(coll.indexes))
+	}
+	var o options.IndexOptions
+	for _, set := range coll.indexes[0].Options.List() {
+		set(&o)
+	}
+	if o.ExpireAfterSeconds == nil || *o.ExpireAfterSeconds != 3600 {
+		t.Errorf("index expires documents after %v seconds, want 3600", o.ExpireAfterSeconds)
+	}
+
+	req, sess := session.NewTestSession(map[string]interface{}{"user": "bob"})
+	for i := 0; i < 2; i++ {
+		if err := store.Save(req, sess); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(coll.docs) != 1 {
+		t.Errorf("%d documents after saving one session twice, want 1", len(coll.docs))
+	}
+	got, ok := store.LoadByID(sess.ID())
+	if !ok {
+		t.Fatal("saved session doesn't load")
+	}
+	if user, _ := got.Data()["user"].(string); user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+
+	store.Destroy(req, got)
+	if _, ok := store.LoadByID(sess.ID()); ok {
+		t.Error("destroyed session loaded")
+	}
+}
+
+func TestMongoStoreMiss(t *testing.T) {
+	coll := newFakeCollection()
+	store := newStore(coll, coll, nil)
+	if _, err := store.LoadChecked("0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e"); err != session.ErrNotFound {
+		t.Errorf("LoadChecked of a missing document = %v, want ErrNotFound", err)
+	}
+}
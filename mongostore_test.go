@@ -0,0 +1,100 @@
+package session
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a document in a fakeMongo collection
+type mongoDoc struct {
+	data      []byte
+	updatedAt time.Time
+	version   int64
+}
+
+//a MongoCollection keeping the documents in a map, which never expires them itself
+type fakeMongo struct {
+	mu      sync.Mutex
+	docs    map[string]mongoDoc
+	upserts int
+	//the TTL indexes created, field to expireAfter
+	indexes map[string]time.Duration
+}
+
+func newFakeMongo() *fakeMongo {
+	return &fakeMongo{docs: make(map[string]mongoDoc), indexes: make(map[string]time.Duration)}
+}
+
+func (m *fakeMongo) FindByID(id string) ([]byte, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.docs[id].data, m.docs[id].version, nil
+}
+
+func (m *fakeMongo) Upsert(id string, data []byte, updatedAt time.Time, version, prev int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.docs[id].version != prev {
+		return ErrConflict
+	}
+	m.docs[id] = mongoDoc{append([]byte(nil), data...), updatedAt, version}
+	m.upserts++
+	return nil
+}
+
+func (m *fakeMongo) DeleteByID(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.docs, id)
+	return nil
+}
+
+func (m *fakeMongo) EnsureTTLIndex(field string, expireAfter time.Duration) error {
+	m.indexes[field] = expireAfter
+	return nil
+}
+
+func TestMongoStore(t *testing.T) {
+	coll := newFakeMongo()
+	clock := newFakeClock()
+	store := NewMongoStore(coll, MaxAge(time.Hour), WithClock(clock))
+	if err := store.EnsureIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := coll.indexes["updatedAt"]; !ok || d != time.Hour {
+		t.Errorf("TTL indexes %v, want updatedAt expiring after an hour", coll.indexes)
+	}
+
+	id := seed(t, store, map[string]interface{}{"n": 1})
+	if doc := coll.docs[id]; !doc.updatedAt.Equal(clock.Now()) {
+		t.Errorf("document updated at %v, want %v", doc.updatedAt, clock.Now())
+	}
+
+	//a second save replaces the document rather than adding one
+	clock.advance(time.Minute)
+	serve(store, func(req *web.Request) {
+		Increment(req, "n", 1)
+	}, sessionCookieName+"="+id)
+	if len(coll.docs) != 1 || coll.upserts != 2 {
+		t.Errorf("%d documents after %d upserts, want 1 after 2", len(coll.docs), coll.upserts)
+	}
+	if doc := coll.docs[id]; !doc.updatedAt.Equal(clock.Now()) {
+		t.Errorf("upsert left the document updated at %v, want %v", doc.updatedAt, clock.Now())
+	}
+	sess, ok := store.LoadByID(id)
+	if !ok {
+		t.Fatal("upserted session doesn't load")
+	}
+	if n, _ := sess.Data()["n"].(int); n != 2 {
+		t.Errorf("loaded n = %v, want 2", sess.Data()["n"])
+	}
+}
+
+func TestMongoStoreMiss(t *testing.T) {
+	store := NewMongoStore(newFakeMongo())
+	if _, err := store.LoadChecked("0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e"); err != ErrNotFound {
+		t.Errorf("LoadChecked of a missing document = %v, want ErrNotFound", err)
+	}
+}
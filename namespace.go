@@ -0,0 +1,63 @@
+package session
+
+import (
+	"strings"
+	"github.com/garyburd/twister/web"
+)
+
+//namespaced values are kept in the session data under this prefix,
+//followed by the namespace's name and a ':'
+const namespacePrefix = "_ns."
+
+//a view of the session holding only the keys of one namespace, so several
+//apps sharing a session cookie can't trample each other's values
+type Scope struct {
+	req    *web.Request
+	prefix string
+}
+
+//the namespace called name in the request's session, names shouldn't contain ':'
+//a namespace holds nothing until a value is set in it
+func Namespace(req *web.Request, name string) *Scope {
+	return &Scope{req: req, prefix: namespacePrefix + name + ":"}
+}
+
+//as GetOK, for the key in the namespace
+func (ns *Scope) Get(key string, ret interface{}) bool {
+	return GetOK(ns.req, ns.prefix+key, ret)
+}
+
+//report whether key is set in the namespace
+func (ns *Scope) Has(key string) bool {
+	return Has(ns.req, ns.prefix+key)
+}
+
+//as Set, for the key in the namespace
+func (ns *Scope) Set(key string, value interface{}) bool {
+	return Set(ns.req, ns.prefix+key, value)
+}
+
+//as Delete, for the key in the namespace
+func (ns *Scope) Delete(key string) bool {
+	return Delete(ns.req, ns.prefix+key)
+}
+
+//remove every key in the namespace, leaving the rest of the session alone
+//returns false if the namespace was already empty
+func (ns *Scope) Clear() bool {
+	sess, ok := writable(ns.req)
+	if !ok {
+		return false
+	}
+
+	return sess.update(func() bool {
+		found := false
+		for k := range sess.data {
+			if strings.HasPrefix(k, ns.prefix) {
+				delete(sess.data, k)
+				found = true
+			}
+		}
+		return found
+	})
+}
@@ -0,0 +1,40 @@
+package session
+
+import (
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	req, _ := NewTestSession(map[string]interface{}{"user": "bob"})
+	admin, shop := Namespace(req, "admin"), Namespace(req, "shop")
+	admin.Set("user", "alice")
+	shop.Set("user", "carol")
+	shop.Set("cart", 3)
+
+	var user string
+	if Get(req, "user", &user); user != "bob" {
+		t.Errorf("session's own user %q, want bob", user)
+	}
+	if admin.Get("user", &user); user != "alice" {
+		t.Errorf("admin's user %q, want alice", user)
+	}
+	if shop.Get("user", &user); user != "carol" {
+		t.Errorf("shop's user %q, want carol", user)
+	}
+	if admin.Has("cart") {
+		t.Error("admin sees shop's cart")
+	}
+
+	if !shop.Clear() {
+		t.Error("Clear reported shop empty")
+	}
+	if shop.Has("user") || shop.Has("cart") {
+		t.Error("shop kept values after Clear")
+	}
+	if !admin.Has("user") || !Has(req, "user") {
+		t.Error("clearing shop cleared other values")
+	}
+	if shop.Clear() {
+		t.Error("Clear of an empty namespace reported values")
+	}
+}
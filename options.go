@@ -0,0 +1,607 @@
+package session
+
+import (
+	"encoding/gob"
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//configuration shared by the session stores, set through the Options
+//passed to the store constructors
+type storeConfig struct {
+	maxAge  time.Duration
+	//how long a session lives after it's created however active it is, 0 for no limit
+	absoluteMaxAge time.Duration
+	sliding bool
+	//the time between passes of the store's sweep loop
+	sweepInterval time.Duration
+	//starts the sweep loop's ticker, nil for a time.Ticker, tests supply their own
+	//to run passes by hand
+	ticker func(d time.Duration) (<-chan time.Time, func())
+	clock   Clock
+	codec   Codec
+	ids     IDGenerator
+	//the largest a session may encode to, 0 for no limit
+	maxBytes int
+	//whether values are checked against the codec as they're set
+	validate bool
+	stats    StatsFunc
+	//the fingerprint sessions are bound to, see Bind
+	bind func(req *web.Request) string
+	//set by stores that can cheaply tell whether an id is in use
+	taken func(id string) bool
+	//the number of saves after which a session's id is regenerated, 0 to never
+	rotateEvery int
+	//what Load does when the backend can't be read
+	failureMode FailureMode
+	//whether the memory store shares string values between sessions, see InternStrings
+	intern bool
+
+	onCreate  func(*Session)
+	onDestroy func(*Session)
+	//the handlers told of each session the store destroys, see destroyWatcher
+	watchers *destroyWatchers
+	onGet     func(id, key string)
+	onSet     func(id, key string, value interface{})
+}
+
+//the source of the current time for a store, tests can supply a Clock they
+//advance by hand to expire sessions without sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+//the Clock used when none is configured
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+//an option for a session store constructor
+type Option func(*storeConfig)
+
+//sessions expire after d without being saved, the default is 1440 seconds
+func MaxAge(d time.Duration) Option {
+	return func(c *storeConfig) {
+		c.maxAge = d
+	}
+}
+
+//stores that sweep out expired sessions do so every d, the default is 600 seconds
+func SweepInterval(d time.Duration) Option {
+	return func(c *storeConfig) {
+		c.sweepInterval = d
+	}
+}
+
+//sessions expire d after they were created, however recently they were used,
+//on top of expiring when they've been idle for the store's MaxAge
+//stores that find expired sessions by how long they've been idle may keep one that's
+//reached d until it idles out too, but it can't be loaded
+func AbsoluteMaxAge(d time.Duration) Option {
+	return func(c *storeConfig) {
+		c.absoluteMaxAge = d
+	}
+}
+
+//sessions expire maxAge after they were last loaded, rather than last written,
+//so users that only read their session aren't logged out
+func Sliding() Option {
+	return func(c *storeConfig) {
+		c.sliding = true
+	}
+}
+
+//use c rather than the system clock for timestamps and expiry
+func WithClock(c Clock) Option {
+	return func(sc *storeConfig) {
+		sc.clock = c
+	}
+}
+
+//the Codec used by stores that serialize sessions, the default is GobCodec
+func WithCodec(codec Codec) Option {
+	return func(c *storeConfig) {
+		c.codec = codec
+	}
+}
+
+//fn is called with each new session, when it's first written to and given its id
+func OnCreate(fn func(*Session)) Option {
+	return func(c *storeConfig) {
+		c.onCreate = fn
+	}
+}
+
+//fn is called with each session that's destroyed or swept from the store
+//stores that rely on the backend to expire sessions only call it on Destroy
+func OnDestroy(fn func(*Session)) Option {
+	return func(c *storeConfig) {
+		c.onDestroy = fn
+	}
+}
+
+//a change that would make a session encode to more than n bytes with the
+//store's codec is refused, the call making it returns false
+func MaxBytes(n int) Option {
+	return func(c *storeConfig) {
+		c.maxBytes = n
+	}
+}
+
+//a session's id is regenerated once it's been saved n times, limiting how long a
+//stolen cookie stays useful, the data moves to the new id and the client is sent
+//a new cookie, 0 (the default) never rotates
+func RotateEvery(n int) Option {
+	return func(c *storeConfig) {
+		c.rotateEvery = n
+	}
+}
+
+//values are encoded with the store's codec as they're set, and Set returns false
+//for one the codec can't handle (a func or chan, or a type gob hasn't had registered),
+//rather than the session failing to save at the end of the request
+//each Set pays for an extra encoding, so this is best kept to development
+//the memory store never encodes values, and accepts anything
+func ValidateValues() Option {
+	return func(c *storeConfig) {
+		c.validate = true
+	}
+}
+
+//receives the figures from each pass of a store's sweep loop: how many sessions
+//the store held, how many were deleted, and how long the pass took
+type StatsFunc func(total, deleted int, took time.Duration)
+
+//fn is called after each sweep in place of the store's log line, so the numbers
+//can be fed to a metrics system
+//stores that leave expiry to their backend don't sweep, and never call it
+func WithStats(fn StatsFunc) Option {
+	return func(c *storeConfig) {
+		c.stats = fn
+	}
+}
+
+//pin each session to a fingerprint of the request that created it, such as UserAgent
+//or IPNetwork, a session loaded by a request with a different fingerprint is refused
+//and the request gets a fresh session, so a stolen cookie is useless elsewhere
+//the fingerprint is kept in the session data, sessions saved before Bind was
+//configured have none and are refused too
+func Bind(fn func(req *web.Request) string) Option {
+	return func(c *storeConfig) {
+		c.bind = fn
+	}
+}
+
+//fn is called each time the accessors read a key from a session, for tracing
+//what a request uses while debugging, it's called without the session locked
+func OnGet(fn func(id, key string)) Option {
+	return func(c *storeConfig) {
+		c.onGet = fn
+	}
+}
+
+//fn is called each time Set, SetMany or Increment sets a key in a session, it's
+//called without the session locked
+func OnSet(fn func(id, key string, value interface{})) Option {
+	return func(c *storeConfig) {
+		c.onSet = fn
+	}
+}
+
+//register an example value of each type other than the basic ones kept in sessions
+//with encoding/gob, so GobCodec can encode and decode them, RegisterTypes(Cart{}) say
+//without it saving a session holding one fails, and so does loading one saved by a
+//process that had registered it
+//registration is global, the types are registered for every store
+func RegisterTypes(values ...interface{}) Option {
+	return func(c *storeConfig) {
+		for _, v := range values {
+			gob.Register(v)
+		}
+	}
+}
+
+//what a store's Load does when its backend can't be read, a database that's down say
+type FailureMode int
+
+const (
+	//the request gets a fresh session that's never saved, so the client keeps its
+	//cookie for when the backend is back, this is the default
+	FailOpen FailureMode = iota
+	//the request is refused with a 503
+	FailClosed
+)
+
+//what Load does when the backend can't be read, only the stores that can tell a
+//failed read from a missing session (the CheckedLoaders) and RetryingStore over
+//one of them use it
+func WithFailureMode(mode FailureMode) Option {
+	return func(c *storeConfig) {
+		c.failureMode = mode
+	}
+}
+
+//the memory store keeps a single copy of each short string value its sessions hold,
+//rather than one per session, saving memory when many sessions hold the same role
+//names or flags say
+//each save looks its session's strings up in a shared table, so it costs a little
+//time, and only the memory store, which keeps sessions unencoded, uses it
+func InternStrings() Option {
+	return func(c *storeConfig) {
+		c.intern = true
+	}
+}
+
+//generate session ids with g rather than the default 16 random bytes
+func WithIDGenerator(g IDGenerator) Option {
+	return func(c *storeConfig) {
+		c.ids = g
+	}
+}
+
+func newStoreConfig(opts []Option) storeConfig {
+	c := storeConfig{maxAge: sessionValidDuration, sweepInterval: sessionSweepInterval,
+		clock: realClock{}, codec: GobCodec{}, ids: randomIDs{}, watchers: &destroyWatchers{}}
+	for _, o := range opts {
+		o(&c)
+	}
+	if c.sweepInterval <= 0 {
+		//a ticker can't run with a zero interval
+		c.sweepInterval = sessionSweepInterval
+	}
+	return c
+}
+
+//how long sessions live in the store, the session cookie is given the same lifetime
+func (c *storeConfig) MaxAge() time.Duration {
+	return c.maxAge
+}
+
+func (c *storeConfig) now() time.Time {
+	return c.clock.Now()
+}
+
+//how long sess lives, the max age given to it with SetMaxAge or the store's default
+func (c *storeConfig) lifetime(sess *Session) time.Duration {
+	if sess.maxAge > 0 {
+		return sess.maxAge
+	}
+	return c.maxAge
+}
+
+//when sess expires, when it's been idle for its lifetime or reaches the store's
+//AbsoluteMaxAge, whichever comes first
+func (c *storeConfig) expiresAt(sess *Session) time.Time {
+	at := sess.timestamp.Add(c.lifetime(sess))
+	//sessions stored before createdAt was recorded only have the idle limit
+	if c.absoluteMaxAge > 0 && !sess.createdAt.IsZero() {
+		if abs := sess.createdAt.Add(c.absoluteMaxAge); abs.Before(at) {
+			at = abs
+		}
+	}
+	return at
+}
+
+//how long a session being saved now has left, for stores whose backend expires keys
+func (c *storeConfig) ttl(sess *Session) time.Duration {
+	//a copy FlushTo is restoring was stamped a while ago, and only has what's left
+	if sess.restored {
+		return c.expiresAt(sess).Sub(c.now())
+	}
+	return c.expiresAt(sess).Sub(sess.timestamp)
+}
+
+//mark sess as saved now, for Save, unless it's a copy FlushTo is restoring, which
+//keeps the timestamp it had so it expires on schedule
+func (c *storeConfig) stamp(sess *Session) {
+	if !sess.restored {
+		sess.timestamp = c.now()
+	}
+}
+
+//ttl in whole seconds, for backends taking one, a session near or past its
+//AbsoluteMaxAge is given a second rather than 0 or less, which redis refuses and
+//memcached takes to mean forever
+func (c *storeConfig) ttlSeconds(sess *Session) int64 {
+	secs := int64(c.ttl(sess) / time.Second)
+	if secs < 1 {
+		return 1
+	}
+	return secs
+}
+
+//whether sess has outlived its lifetime
+func (c *storeConfig) expired(sess *Session) bool {
+	return c.expiresAt(sess).Before(c.now())
+}
+
+//the longest session id accepted from a client, anything longer is treated as no id
+//rather than being passed on to the backend
+const maxIDLength = 128
+
+//the session id the client sent, "" if it sent none or one that can't be one of the
+//store's ids, so junk from a client never reaches the backend
+func (c *storeConfig) loadID(req *web.Request) string {
+	id := requestID(req)
+	if !c.validID(id) {
+		return ""
+	}
+	return id
+}
+
+//whether id could be one of the store's ids
+func (c *storeConfig) validID(id string) bool {
+	if len(id) > maxIDLength {
+		return false
+	}
+	if _, ok := c.ids.(randomIDs); ok && !isUUID(id) {
+		//only the default generator's format is known
+		return false
+	}
+	return true
+}
+
+//whether id is laid out like the ids uuid generates
+func isUUID(id string) bool {
+	if len(id) != 36 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//Load for a store that's a CheckedLoader
+func (c *storeConfig) loadChecked(req *web.Request, l CheckedLoader) *Session {
+	id := c.loadID(req)
+	if id == "" {
+		return c.fresh(req)
+	}
+	sess, err := l.LoadChecked(id)
+	if err == ErrNotFound {
+		return c.fresh(req)
+	}
+	if err != nil {
+		log.Printf("session: could not load session %s: %v", id, err)
+		return c.failed(req, err)
+	}
+	return c.loaded(req, sess)
+}
+
+//the session for a request whose session couldn't be loaded, as the FailureMode says
+//with FailClosed there's none, and the handler answers with a 503
+func (c *storeConfig) failed(req *web.Request, err error) *Session {
+	if c.failureMode == FailClosed {
+		req.Env["sessionError"] = err
+		return nil
+	}
+	sess := c.fresh(req)
+	sess.ephemeral = true
+	return sess
+}
+
+//a new session for a request without one, see freshSession
+func (c *storeConfig) fresh(req *web.Request) *Session {
+	sess := freshSession()
+	sess.timestamp = c.now()
+	sess.createdAt = sess.timestamp
+	if c.bind != nil {
+		sess.fingerprint = c.bind(req)
+	}
+	sess.onCreate = c.onCreate
+	sess.onGet, sess.onSet = c.onGet, c.onSet
+	sess.ids = c.idGenerator()
+	sess.fits = c.sizeCheck()
+	sess.valid = c.valueCheck()
+	sess.rotateEvery = c.rotateEvery
+	sess.new = true
+	return sess
+}
+
+//the generator for the store's sessions, it refuses ids the store already holds
+func (c *storeConfig) idGenerator() IDGenerator {
+	return &uniqueIDs{ids: c.ids, taken: c.taken}
+}
+
+//reports whether a session is within the store's MaxBytes, nil when there's no limit
+//a session that can't be encoded passes, Save will report the problem
+func (c *storeConfig) sizeCheck() func(*Session) bool {
+	if c.maxBytes <= 0 {
+		return nil
+	}
+	return func(sess *Session) bool {
+		b, err := c.codec.Marshal(sess)
+		return err != nil || len(b) <= c.maxBytes
+	}
+}
+
+//reports whether the store's codec can encode a value, nil unless ValidateValues is set
+func (c *storeConfig) valueCheck() func(interface{}) bool {
+	if !c.validate {
+		return nil
+	}
+	return func(value interface{}) bool {
+		probe := &Session{data: map[string]interface{}{"": value}}
+		_, err := c.codec.Marshal(probe)
+		return err == nil
+	}
+}
+
+//how many times uniqueIDs will generate an id before giving up
+const idAttempts = 3
+
+//wraps an IDGenerator, retrying when it gives an empty id or one that's in use
+type uniqueIDs struct {
+	ids   IDGenerator
+	taken func(id string) bool
+}
+
+func (u *uniqueIDs) NewID() (string, error) {
+	for i := 0; i < idAttempts; i++ {
+		id, err := u.ids.NewID()
+		if err != nil {
+			return "", err
+		}
+		if id != "" && (u.taken == nil || !u.taken(id)) {
+			return id, nil
+		}
+	}
+	return "", errIDCollision
+}
+
+var errIDCollision = errors.New("session: could not generate an unused session id")
+
+//returned by Save when the session has been saved by another request since it was
+//loaded, saving it would throw away that request's changes
+//reload the session and make the change again to retry
+//the memory store shares one Session between the requests using it, so it never
+//reports a conflict
+var ErrConflict = errors.New("session: session was saved by another request since it was loaded")
+
+//move sess on to its next version for a save, current is the copy in the store,
+//nil if there isn't one
+//returns ErrConflict, leaving sess alone, when current is newer than sess
+func (c *storeConfig) nextVersion(current, sess *Session) error {
+	if current != nil && current.version > sess.version {
+		//a copy FlushTo is restoring replaces what's stored
+		if !sess.restored {
+			return ErrConflict
+		}
+		sess.version = current.version
+	}
+	sess.version++
+	return nil
+}
+
+//called by the stores on each session they remove, this must not be called with
+//the store locked as the hook may use the store
+func (c *storeConfig) destroyed(sess *Session) {
+	//a session that was moved to a new id by Regenerate lives on
+	if sess.id == "" || sess.moved {
+		return
+	}
+	if c.onDestroy != nil {
+		c.onDestroy(sess)
+	}
+	c.watchers.notify(sess.id)
+}
+
+//call fn with the id of each session the store destroys, whether by Destroy or
+//by a sweep, see destroyWatcher
+func (c *storeConfig) watchDestroy(fn func(id string)) {
+	c.watchers.mu.Lock()
+	c.watchers.fns = append(c.watchers.fns, fn)
+	c.watchers.mu.Unlock()
+}
+
+//the funcs passed to a store's watchDestroy
+type destroyWatchers struct {
+	mu  sync.Mutex
+	fns []func(id string)
+}
+
+func (w *destroyWatchers) notify(id string) {
+	w.mu.Lock()
+	fns := w.fns
+	w.mu.Unlock()
+	for _, fn := range fns {
+		fn(id)
+	}
+}
+
+//called by the stores on each session they load, with sliding expiration the
+//session's timestamp is bumped and it's marked for saving so the store sees the new expiry,
+//unless the handler is configured with NoTouch
+func (c *storeConfig) loaded(req *web.Request, sess *Session) *Session {
+	if c.bind != nil {
+		sess.mu.RLock()
+		fp, _ := sess.data[fingerprintKey].(string)
+		sess.mu.RUnlock()
+		if fp != c.bind(req) {
+			log.Printf("session: refusing session %s, it was created by a different client", sess.id)
+			return c.fresh(req)
+		}
+	}
+
+	sess.mu.Lock()
+	//the store's generator is used if the session is regenerated
+	sess.ids = c.idGenerator()
+	sess.fits = c.sizeCheck()
+	sess.valid = c.valueCheck()
+	sess.rotateEvery = c.rotateEvery
+	//the memory store hands out the same Session it was saved as
+	sess.new = false
+	sess.onGet, sess.onSet = c.onGet, c.onSet
+	if c.sliding && !noTouch(req) {
+		sess.timestamp = c.now()
+		sess.dirty = true
+	}
+	sess.mu.Unlock()
+	return sess
+}
+
+//managers implementing maxAger have the session cookie expire along with the session,
+//for any other manager the cookie lasts until the browser is closed
+type maxAger interface {
+	MaxAge() time.Duration
+}
+
+//managers embedding storeConfig implement expirer, the handler uses it to give the
+//session cookie the session's remaining lifetime
+type expirer interface {
+	expiresAt(sess *Session) time.Time
+}
+
+//managers embedding storeConfig implement destroyWatcher, the handler uses it to
+//forget the sessions a store has swept from its MaxSessionsPerUser index
+type destroyWatcher interface {
+	watchDestroy(fn func(id string))
+}
+
+//managers embedding storeConfig implement clocked, the handler uses the store's
+//clock to work out how long a session has left
+type clocked interface {
+	now() time.Time
+}
+
+//the ticks a sweep loop waits on between passes, and the func stopping them
+func (c *storeConfig) sweepTicker() (<-chan time.Time, func()) {
+	if c.ticker != nil {
+		return c.ticker(c.sweepInterval)
+	}
+	t := time.NewTicker(c.sweepInterval)
+	return t.C, t.Stop
+}
+
+//the stop signal for a store's background sweep loop
+type sweeper struct {
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func newSweeper() sweeper {
+	return sweeper{stop: make(chan struct{})}
+}
+
+//stop the store's sweep loop, it's safe to call more than once
+func (w *sweeper) Close() {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+	})
+}
@@ -0,0 +1,215 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a Clock that only moves when it's told to
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2012, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+//a sweep ticker the test ticks by hand, stopped is closed when the sweep loop
+//returns
+type fakeTicker struct {
+	c        chan time.Time
+	stopped  chan struct{}
+	interval time.Duration
+}
+
+func newFakeTicker() *fakeTicker {
+	return &fakeTicker{c: make(chan time.Time), stopped: make(chan struct{})}
+}
+
+func (f *fakeTicker) option() Option {
+	return func(c *storeConfig) {
+		c.ticker = func(d time.Duration) (<-chan time.Time, func()) {
+			f.interval = d
+			return f.c, func() { close(f.stopped) }
+		}
+	}
+}
+
+//whether a request bringing the cookie for id finds its session
+func loads(store SessionManager, id string) bool {
+	found := false
+	serve(store, func(req *web.Request) {
+		found = Has(req, "a")
+	}, sessionCookieName+"="+id)
+	return found
+}
+
+func TestSliding(t *testing.T) {
+	for _, sliding := range []bool{false, true} {
+		clock := newFakeClock()
+		opts := []Option{MaxAge(10 * time.Minute), WithClock(clock)}
+		if sliding {
+			opts = append(opts, Sliding())
+		}
+		store := MemoryStoreNoSweep(opts...)
+		id := seed(t, store, map[string]interface{}{"a": 1})
+
+		//read it part way through its life, then go past when it was due to expire
+		clock.advance(6 * time.Minute)
+		if !loads(store, id) {
+			t.Fatalf("sliding %v: session expired early", sliding)
+		}
+		clock.advance(6 * time.Minute)
+		if loads(store, id) != sliding {
+			t.Errorf("sliding %v: session loaded %v, 12 minutes after it was saved and 6 after it was read",
+				sliding, !sliding)
+		}
+	}
+}
+
+func TestClockExpiry(t *testing.T) {
+	clock := newFakeClock()
+	store := MemoryStoreNoSweep(MaxAge(10*time.Minute), WithClock(clock))
+	id := seed(t, store, map[string]interface{}{"a": 1})
+
+	clock.advance(9 * time.Minute)
+	if _, ok := store.LoadByID(id); !ok {
+		t.Fatal("session expired before its max age")
+	}
+	clock.advance(2 * time.Minute)
+	if _, ok := store.LoadByID(id); ok {
+		t.Error("session loaded past its max age")
+	}
+	if _, deleted := store.sweep(clock.Now()); deleted != 1 {
+		t.Errorf("sweep deleted %d sessions, want 1", deleted)
+	}
+}
+
+func TestAccessHooks(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	record := func(s string) {
+		mu.Lock()
+		calls = append(calls, s)
+		mu.Unlock()
+	}
+	store := MemoryStoreNoSweep(
+		OnGet(func(id, key string) { record("get " + key) }),
+		OnSet(func(id, key string, value interface{}) { record(fmt.Sprintf("set %s=%v", key, value)) }),
+	)
+	id := seed(t, store, map[string]interface{}{"n": 1})
+	calls = nil
+
+	serve(store, func(req *web.Request) {
+		n, _ := GetInt(req, "n")
+		Set(req, "n", n+1)
+		Set(req, "seen", true)
+	}, sessionCookieName+"="+id)
+	want := []string{"get n", "set n=2", "set seen=true"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks saw %q, want %q", calls, want)
+	}
+}
+
+func TestAbsoluteMaxAge(t *testing.T) {
+	clock := newFakeClock()
+	store := MemoryStoreNoSweep(MaxAge(10*time.Minute), AbsoluteMaxAge(30*time.Minute), Sliding(), WithClock(clock))
+	id := seed(t, store, map[string]interface{}{"a": 1})
+
+	//used every five minutes, so it's never idle long enough to expire
+	for i := 0; i < 5; i++ {
+		clock.advance(5 * time.Minute)
+		if !loads(store, id) {
+			t.Fatalf("active session expired %d minutes in", (i+1)*5)
+		}
+	}
+	clock.advance(6 * time.Minute)
+	if loads(store, id) {
+		t.Error("active session loaded past its absolute max age")
+	}
+}
+
+func TestRotateEvery(t *testing.T) {
+	store := MemoryStoreNoSweep(RotateEvery(3))
+	id := seed(t, store, map[string]interface{}{"n": 0})
+
+	//the seed was the first save
+	ids := []string{id}
+	for i := 0; i < 3; i++ {
+		rec := serve(store, func(req *web.Request) {
+			Increment(req, "n", 1)
+		}, sessionCookieName+"="+id)
+		if c := cookieValue(rec, sessionCookieName); c != "" {
+			id = c
+		}
+		ids = append(ids, id)
+	}
+	if ids[1] != ids[0] || ids[2] != ids[0] {
+		t.Errorf("id changed before 3 saves: %v", ids)
+	}
+	if ids[3] == ids[0] {
+		t.Fatal("id unchanged after 3 saves")
+	}
+	if _, ok := store.LoadByID(ids[0]); ok {
+		t.Error("session still stored under its old id")
+	}
+	sess, ok := store.LoadByID(ids[3])
+	if !ok {
+		t.Fatal("rotated session not stored under its new id")
+	}
+	if n, _ := sess.Data()["n"].(int); n != 3 {
+		t.Errorf("rotated session has n = %v, want 3", sess.Data()["n"])
+	}
+}
+
+func TestFailureMode(t *testing.T) {
+	for _, mode := range []FailureMode{FailOpen, FailClosed} {
+		client := newFakeRedis()
+		store := RedisStoreWithClient(client, WithFailureMode(mode))
+		id := seed(t, store, map[string]interface{}{"user": "bob"})
+		client.err = errors.New("connection refused")
+
+		ran := false
+		var user string
+		rec := serve(store, func(req *web.Request) {
+			ran = true
+			Get(req, "user", &user)
+			Set(req, "user", "eve")
+		}, sessionCookieName+"="+id)
+		switch mode {
+		case FailOpen:
+			if !ran || user != "" {
+				t.Errorf("FailOpen: handler ran %v with user %q, want it run with a fresh session", ran, user)
+			}
+			if c := setCookie(rec, sessionCookieName); c != "" {
+				t.Errorf("FailOpen: stand in session sent cookie %q", c)
+			}
+		case FailClosed:
+			if ran || rec.status != web.StatusServiceUnavailable {
+				t.Errorf("FailClosed: handler ran %v, status %d, want a 503", ran, rec.status)
+			}
+		}
+		client.err = nil
+		if sess, _ := store.LoadByID(id); sess.Data()["user"] != "bob" {
+			t.Errorf("mode %d: stored session changed while the backend was down", mode)
+		}
+	}
+}
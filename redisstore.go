@@ -0,0 +1,236 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"github.com/garyburd/twister/web"
+)
+
+//the redis commands the RedisStore needs, this lets the store run against
+//any client (or a fake in tests)
+//Get returns a nil slice and no error when the key doesn't exist
+//CompareAndSetEx sets the key as SETEX does, but only while it still holds prev,
+//a nil prev for a key that mustn't exist, and returns ErrConflict when it doesn't,
+//the check and the write are atomic in redis
+type RedisClient interface {
+	Get(key string) ([]byte, error)
+	CompareAndSetEx(key string, prev []byte, seconds int, value []byte) error
+	Del(key string) error
+}
+
+//a redis backed session store, sessions are shared by every server that
+//points at the same redis instance
+type RedisStore struct {
+	storeConfig
+	client RedisClient
+}
+
+//ctor, connects to the redis server at addr
+//password and db are optional, pass "" and 0 to skip AUTH and SELECT
+func NewRedisStore(addr, password string, db int, opts ...Option) *RedisStore {
+	return RedisStoreWithClient(&redisConn{addr: addr, password: password, db: db}, opts...)
+}
+
+//ctor for a RedisStore using an already configured client
+func RedisStoreWithClient(client RedisClient, opts ...Option) *RedisStore {
+	return &RedisStore{storeConfig: newStoreConfig(opts), client: client}
+}
+
+//the key a session is stored under in key/value stores
+func sessionKey(id string) string {
+	return "session:" + id
+}
+
+//a backend that can't be read is handled as the store's FailureMode says
+func (s *RedisStore) Load(req *web.Request) *Session {
+	return s.loadChecked(req, s)
+}
+
+//the live session stored under id, see IDLoader
+func (s *RedisStore) LoadByID(id string) (*Session, bool) {
+	return s.loadKVByID(s, "redis", id)
+}
+
+//LoadByID, with the error when the backend couldn't be read, see CheckedLoader
+func (s *RedisStore) LoadChecked(id string) (*Session, error) {
+	return s.loadKV(s, id)
+}
+
+//the encoded session under id, see kvBackend
+func (s *RedisStore) get(id string) (kvEntry, error) {
+	b, err := s.client.Get(sessionKey(id))
+	return kvEntry{b: b}, err
+}
+
+//redis compares the stored value itself
+func (s *RedisStore) put(sess *Session, b []byte, ttl int64, prev kvEntry) error {
+	return s.client.CompareAndSetEx(sessionKey(sess.id), prev.b, int(ttl), b)
+}
+
+func (s *RedisStore) Save(req *web.Request, sess *Session) error {
+	return s.saveKV(s, sess)
+}
+
+func (s *RedisStore) Destroy(req *web.Request, sess *Session) {
+	if err := s.client.Del(sessionKey(sess.id)); err != nil {
+		log.Printf("session: redis delete of %s failed: %v", sess.id, err)
+	}
+	s.destroyed(sess)
+}
+
+//redis expires the keys itself, so there's nothing to sweep
+func (s *RedisStore) Sweep() {}
+
+//a minimal redis client speaking the unified request protocol over a single connection
+//the connection is dialed lazily, and redialed after any network error
+type redisConn struct {
+	addr     string
+	password string
+	db       int
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+//an error reply from the redis server, the connection is still usable after one of these
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (c *redisConn) Get(key string) ([]byte, error) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		return nil, err
+	}
+	b, _ := reply.([]byte)
+	return b, nil
+}
+
+//sets the key when the sha1 of what it holds matches, "" standing for no key,
+//so the old value needn't be sent back to the server
+const compareAndSetEx = `local cur = redis.call('GET', KEYS[1])
+if (cur and redis.sha1hex(cur) or '') ~= ARGV[1] then return 0 end
+redis.call('SETEX', KEYS[1], ARGV[2], ARGV[3])
+return 1`
+
+func (c *redisConn) CompareAndSetEx(key string, prev []byte, seconds int, value []byte) error {
+	sum := ""
+	if prev != nil {
+		h := sha1.Sum(prev)
+		sum = hex.EncodeToString(h[:])
+	}
+	reply, err := c.do("EVAL", compareAndSetEx, "1", key, sum, strconv.Itoa(seconds), string(value))
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n != 1 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (c *redisConn) Del(key string) error {
+	_, err := c.do("DEL", key)
+	return err
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.roundTrip(args)
+	if _, ok := err.(redisError); err != nil && !ok {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+func (c *redisConn) dial() error {
+	conn, err := net.Dial("tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+
+	if c.password != "" {
+		if _, err := c.roundTrip([]string{"AUTH", c.password}); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.roundTrip([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			c.conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisConn) roundTrip(args []string) (interface{}, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+	return nil, errors.New("redis: unexpected reply " + line)
+}
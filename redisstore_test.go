@@ -0,0 +1,127 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"github.com/garyburd/twister/web"
+)
+
+//a RedisClient keeping the keys in a map, with the ttl each was last set with
+type fakeRedis struct {
+	mu   sync.Mutex
+	keys map[string][]byte
+	ttls map[string]int
+	//returned from every call when set
+	err error
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{keys: make(map[string][]byte), ttls: make(map[string]int)}
+}
+
+func (r *fakeRedis) Get(key string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.keys[key], r.err
+}
+
+func (r *fakeRedis) CompareAndSetEx(key string, prev []byte, seconds int, value []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	if cur, ok := r.keys[key]; ok != (prev != nil) || !bytes.Equal(cur, prev) {
+		return ErrConflict
+	}
+	r.keys[key] = append([]byte(nil), value...)
+	r.ttls[key] = seconds
+	return nil
+}
+
+func (r *fakeRedis) Del(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, key)
+	delete(r.ttls, key)
+	return r.err
+}
+
+func TestRedisStore(t *testing.T) {
+	client := newFakeRedis()
+	store := RedisStoreWithClient(client)
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	if ttl := client.ttls[sessionKey(id)]; ttl != int(sessionValidDuration.Seconds()) {
+		t.Errorf("saved with a ttl of %d, want %v", ttl, sessionValidDuration.Seconds())
+	}
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+		Destroy(req)
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+	if _, ok := client.keys[sessionKey(id)]; ok {
+		t.Error("destroyed session still in redis")
+	}
+}
+
+func TestRedisStoreMiss(t *testing.T) {
+	store := RedisStoreWithClient(newFakeRedis())
+	if _, ok := store.LoadByID("0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e"); ok {
+		t.Error("loaded a session redis doesn't have")
+	}
+	var isNew bool
+	serve(store, func(req *web.Request) {
+		isNew = IsNew(req)
+	}, sessionCookieName+"=0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e")
+	if !isNew {
+		t.Error("unknown id didn't give a new session")
+	}
+}
+
+func TestRedisStoreSaveError(t *testing.T) {
+	client := newFakeRedis()
+	client.err = errors.New("connection refused")
+	store := RedisStoreWithClient(client)
+	rec := serve(store, func(req *web.Request) {
+		Set(req, "a", 1)
+	})
+	if c := setCookie(rec, sessionCookieName); c != "" {
+		t.Errorf("cookie %q sent for a session redis didn't store", c)
+	}
+}
+
+func TestRedisStoreConflict(t *testing.T) {
+	store := RedisStoreWithClient(newFakeRedis())
+	id := seed(t, store, map[string]interface{}{"n": 0})
+
+	//two requests load the session, the second to finish saves a stale copy
+	rec := serve(store, func(req *web.Request) {
+		serve(store, func(req *web.Request) {
+			Set(req, "n", 1)
+		}, sessionCookieName+"="+id)
+		Set(req, "n", 2)
+	}, sessionCookieName+"="+id)
+	if c := setCookie(rec, sessionCookieName); c != "" {
+		t.Errorf("stale save sent cookie %q", c)
+	}
+	sess, _ := store.LoadByID(id)
+	if n, _ := sess.Data()["n"].(int); n != 1 {
+		t.Errorf("stored n = %v, want the first save's 1", sess.Data()["n"])
+	}
+
+	a, _ := store.LoadByID(id)
+	b, _ := store.LoadByID(id)
+	req := &web.Request{Env: make(map[string]interface{})}
+	if err := store.Save(req, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(req, b); err != ErrConflict {
+		t.Errorf("saving a stale copy gave %v, want ErrConflict", err)
+	}
+}
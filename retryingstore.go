@@ -0,0 +1,150 @@
+package session
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a session store retrying the loads and saves of another store that fail, so a
+//network blip to redis or the database doesn't cost a user their session
+//saves are only retried when they fail talking to the backend
+//loads are only retried when the backend is a CheckedLoader, as otherwise a failed
+//load looks the same as a missing session
+type RetryingStore struct {
+	backend SessionManager
+	//how many times a load or save is tried in all
+	attempts int
+	//the wait before the first retry, doubled for each retry after it
+	backoff time.Duration
+}
+
+//ctor, each load or save is tried up to attempts times, waiting backoff before the
+//first retry and twice as long before each one after
+func NewRetryingStore(backend SessionManager, attempts int, backoff time.Duration) *RetryingStore {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &RetryingStore{backend: backend, attempts: attempts, backoff: backoff}
+}
+
+//what the RetryingStore needs to load sessions itself, promoted from the backend's
+//storeConfig
+type checkedStore interface {
+	CheckedLoader
+	loaded(req *web.Request, sess *Session) *Session
+	fresh(req *web.Request) *Session
+	loadID(req *web.Request) string
+	failed(req *web.Request, err error) *Session
+}
+
+//run fn until it succeeds, fails with an error retry says is permanent, or has
+//been tried s.attempts times, returning its last error
+func (s *RetryingStore) do(what string, retry func(error) bool, fn func() error) error {
+	wait := s.backoff
+	var err error
+	for i := 0; i < s.attempts; i++ {
+		if i > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		if err = fn(); err == nil || !retry(err) {
+			return err
+		}
+	}
+	log.Printf("session: giving up on %s after %d attempts: %v", what, s.attempts, err)
+	return err
+}
+
+//a session that isn't there won't appear on a retry, and when the retries run out
+//the backend's FailureMode applies
+func (s *RetryingStore) Load(req *web.Request) *Session {
+	c, ok := s.backend.(checkedStore)
+	if !ok {
+		return s.backend.Load(req)
+	}
+	id := c.loadID(req)
+	if id == "" {
+		return c.fresh(req)
+	}
+
+	var sess *Session
+	err := s.do("loading session "+id, func(err error) bool { return err != ErrNotFound }, func() error {
+		var err error
+		sess, err = c.LoadChecked(id)
+		return err
+	})
+	if err == ErrNotFound {
+		return c.fresh(req)
+	}
+	if err != nil {
+		return c.failed(req, err)
+	}
+	return c.loaded(req, sess)
+}
+
+//only a save lost to the network or the disk is retried, one refused because the
+//session is stale, its id is taken, or it won't encode or fit fails the same way
+//every time
+func (s *RetryingStore) Save(req *web.Request, sess *Session) error {
+	return s.do("saving session "+sess.id, transient,
+		func() error {
+			return s.backend.Save(req, sess)
+		})
+}
+
+func (s *RetryingStore) Destroy(req *web.Request, sess *Session) {
+	s.backend.Destroy(req, sess)
+}
+
+//reports whether err is a failure talking to the backend, which may not happen on
+//another try, rather than the backend or the codec refusing the session
+func transient(err error) bool {
+	var netErr net.Error
+	var pathErr *os.PathError
+	return errors.As(err, &netErr) || errors.As(err, &pathErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn)
+}
+
+//the backend sweeps itself, so there's nothing to do
+func (s *RetryingStore) Sweep() {}
+
+//the backend's MaxAge, so the session cookie lasts as long as the backend's sessions
+func (s *RetryingStore) MaxAge() time.Duration {
+	if m, ok := s.backend.(maxAger); ok {
+		return m.MaxAge()
+	}
+	return 0
+}
+
+//when the backend expires sess, see expirer, zero if the backend can't say
+func (s *RetryingStore) expiresAt(sess *Session) time.Time {
+	if e, ok := s.backend.(expirer); ok {
+		return e.expiresAt(sess)
+	}
+	return time.Time{}
+}
+
+//watch the backend's destroyed sessions, see destroyWatcher
+func (s *RetryingStore) watchDestroy(fn func(id string)) {
+	if w, ok := s.backend.(destroyWatcher); ok {
+		w.watchDestroy(fn)
+	}
+}
+
+//the backend's clock, see clocked
+func (s *RetryingStore) now() time.Time {
+	if c, ok := s.backend.(clocked); ok {
+		return c.now()
+	}
+	return time.Now()
+}
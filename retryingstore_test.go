@@ -0,0 +1,101 @@
+package session
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a RedisClient failing its next failures calls, counting every call
+type flakyRedis struct {
+	*fakeRedis
+	mu       sync.Mutex
+	failures int
+	calls    int
+}
+
+func (r *flakyRedis) fail() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.failures > 0 {
+		r.failures--
+		return &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	}
+	return nil
+}
+
+func (r *flakyRedis) Get(key string) ([]byte, error) {
+	if err := r.fail(); err != nil {
+		return nil, err
+	}
+	return r.fakeRedis.Get(key)
+}
+
+func (r *flakyRedis) CompareAndSetEx(key string, prev []byte, seconds int, value []byte) error {
+	if err := r.fail(); err != nil {
+		return err
+	}
+	return r.fakeRedis.CompareAndSetEx(key, prev, seconds, value)
+}
+
+func TestRetryingStore(t *testing.T) {
+	client := &flakyRedis{fakeRedis: newFakeRedis()}
+	store := NewRetryingStore(RedisStoreWithClient(client), 3, time.Millisecond)
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	client.failures, client.calls = 2, 0
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("load failing twice loaded user %q, want bob", user)
+	}
+	if client.calls != 3 {
+		t.Errorf("load took %d calls, want 3", client.calls)
+	}
+
+	//a missing session isn't retried
+	client.calls = 0
+	serve(store, func(req *web.Request) {}, sessionCookieName+"=0d5a3e5c-6d9e-4a39-8a76-38c5e6fd8c3e")
+	if client.calls != 1 {
+		t.Errorf("loading a missing session took %d calls, want 1", client.calls)
+	}
+}
+
+func TestRetryingStoreGivesUp(t *testing.T) {
+	client := &flakyRedis{fakeRedis: newFakeRedis(), failures: 100}
+	store := NewRetryingStore(RedisStoreWithClient(client), 3, time.Millisecond)
+	rec := serve(store, func(req *web.Request) {
+		Set(req, "user", "bob")
+	})
+	if c := setCookie(rec, sessionCookieName); c != "" {
+		t.Errorf("cookie %q sent for a session that was never saved", c)
+	}
+	//each attempt fails reading the stored version, before it gets to write
+	if client.calls != 3 {
+		t.Errorf("save took %d calls, want 1 for each of 3 attempts", client.calls)
+	}
+}
+
+func TestRetryingStoreCodecError(t *testing.T) {
+	client := &flakyRedis{fakeRedis: newFakeRedis()}
+	store := NewRetryingStore(RedisStoreWithClient(client), 3, time.Millisecond)
+	//gob hasn't been told about the type, so the session won't encode however
+	//many times it's tried
+	type unregistered struct{ N int }
+	rec := serve(store, func(req *web.Request) {
+		Set(req, "value", unregistered{1})
+	})
+	if c := setCookie(rec, sessionCookieName); c != "" {
+		t.Errorf("cookie %q sent for a session that doesn't encode", c)
+	}
+	//the read of the stored version, then the encoding fails
+	if client.calls != 1 {
+		t.Errorf("save took %d calls, want 1 for a single attempt", client.calls)
+	}
+}
@@ -0,0 +1,139 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"github.com/garyburd/twister/web"
+)
+
+//browsers won't store cookies larger than this
+const maxCookieSize = 4096
+
+//a session store that keeps nothing on the server, the session is encoded with the store's codec,
+//encrypted and authenticated with AES-GCM, and sent to the client as the cookie value
+//sessions that don't fit in a cookie can't be saved
+type SecureCookieStore struct {
+	storeConfig
+	//sessions are sealed with the first, and opened with any of them
+	aeads []cipher.AEAD
+}
+
+//ctor, key must be 32 bytes long (AES-256)
+//unless MaxBytes is given, sessions are limited to what will fit in a cookie once encrypted
+func NewSecureCookieStore(key []byte, opts ...Option) (*SecureCookieStore, error) {
+	return NewSecureCookieStoreKeys([][]byte{key}, opts...)
+}
+
+//ctor for a store in the middle of a key rotation, sessions are encrypted with keys[0],
+//and cookies encrypted with any of the keys are accepted and re-encrypted with keys[0]
+//every key must be 32 bytes long
+func NewSecureCookieStoreKeys(keys [][]byte, opts ...Option) (*SecureCookieStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: secure cookie store needs a key")
+	}
+	s := &SecureCookieStore{storeConfig: newStoreConfig(opts)}
+	for _, key := range keys {
+		if len(key) != 32 {
+			return nil, errors.New("session: secure cookie key must be 32 bytes")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		s.aeads = append(s.aeads, aead)
+	}
+	if s.maxBytes == 0 {
+		//the nonce and tag are added, then the lot is base64 encoded
+		aead := s.aeads[0]
+		s.maxBytes = maxCookieSize/4*3 - aead.NonceSize() - aead.Overhead()
+	}
+	return s, nil
+}
+
+//a cookie that's missing, too large, tampered with or expired gives a fresh session
+func (s *SecureCookieStore) Load(req *web.Request) *Session {
+	val := requestID(req)
+	if val == "" || len(val) > maxCookieSize {
+		return s.fresh(req)
+	}
+
+	sess, oldKey, err := s.decodeCookie(val)
+	if err != nil {
+		log.Printf("session: rejecting session cookie: %v", err)
+		return s.fresh(req)
+	}
+	if s.expired(sess) {
+		return s.fresh(req)
+	}
+	if oldKey {
+		//saving the session sends it back encrypted with the current key
+		sess.dirty = true
+	}
+	return s.loaded(req, sess)
+}
+
+//there's nothing to store server side, Save only checks the session fits in a cookie
+func (s *SecureCookieStore) Save(req *web.Request, sess *Session) error {
+	sess.timestamp = s.now()
+	val, err := s.encodeCookie(sess)
+	if err != nil {
+		return err
+	}
+	if len(val) > maxCookieSize {
+		return fmt.Errorf("session: session is %d bytes, too large for a cookie", len(val))
+	}
+	return nil
+}
+
+//the handler expires the cookie, there's nothing else to remove
+func (s *SecureCookieStore) Destroy(req *web.Request, sess *Session) {
+	s.destroyed(sess)
+}
+
+//expired cookies are rejected in Load, there's nothing to sweep
+func (s *SecureCookieStore) Sweep() {}
+
+func (s *SecureCookieStore) encodeCookie(sess *Session) (string, error) {
+	b, err := s.codec.Marshal(sess)
+	if err != nil {
+		return "", err
+	}
+
+	aead := s.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, b, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+//returns whether the cookie was encrypted with one of the old keys too
+func (s *SecureCookieStore) decodeCookie(val string) (*Session, bool, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, false, err
+	}
+	for i, aead := range s.aeads {
+		n := aead.NonceSize()
+		if len(sealed) < n {
+			return nil, false, errors.New("session: cookie too short")
+		}
+		b, err := aead.Open(nil, sealed[:n], sealed[n:], nil)
+		if err != nil {
+			continue
+		}
+		sess, err := s.codec.Unmarshal(b)
+		return sess, i > 0, err
+	}
+	return nil, false, errors.New("session: cookie doesn't decrypt with any key")
+}
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"bytes"
+	"testing"
+	"github.com/garyburd/twister/web"
+)
+
+var testKey = bytes.Repeat([]byte("k"), 32)
+
+func TestSecureCookieRoundTrip(t *testing.T) {
+	store, err := NewSecureCookieStore(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(store, func(req *web.Request) {
+		Set(req, "user", "bob")
+	})
+	val := cookieValue(rec, sessionCookieName)
+	if val == "" {
+		t.Fatal("no session cookie sent")
+	}
+	if bytes.Contains([]byte(val), []byte("bob")) {
+		t.Error("session data readable in the cookie")
+	}
+
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+	}, sessionCookieName+"="+val)
+	if user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+}
+
+func TestSecureCookieTampered(t *testing.T) {
+	store, err := NewSecureCookieStore(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(store, func(req *web.Request) {
+		Set(req, "user", "bob")
+	})
+	val := []byte(cookieValue(rec, sessionCookieName))
+	//flip a bit in the ciphertext, staying within the base64 alphabet
+	if val[20] == 'A' {
+		val[20] = 'B'
+	} else {
+		val[20] = 'A'
+	}
+
+	var isNew bool
+	var user string
+	serve(store, func(req *web.Request) {
+		isNew = IsNew(req)
+		Get(req, "user", &user)
+	}, sessionCookieName+"="+string(val))
+	if !isNew || user != "" {
+		t.Errorf("tampered cookie loaded, user %q", user)
+	}
+}
+
+func TestSecureCookieKeyRotation(t *testing.T) {
+	oldKey, newKey := testKey, bytes.Repeat([]byte("n"), 32)
+	old, err := NewSecureCookieStore(oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(old, func(req *web.Request) {
+		Set(req, "user", "bob")
+	})
+	val := cookieValue(rec, sessionCookieName)
+
+	store, err := NewSecureCookieStoreKeys([][]byte{newKey, oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user string
+	rec = serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+	}, sessionCookieName+"="+val)
+	if user != "bob" {
+		t.Fatalf("cookie encrypted with the old key loaded user %q, want bob", user)
+	}
+	resealed := cookieValue(rec, sessionCookieName)
+	if resealed == "" || resealed == val {
+		t.Fatal("cookie encrypted with the old key wasn't resent")
+	}
+
+	current, err := NewSecureCookieStore(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user = ""
+	serve(current, func(req *web.Request) {
+		Get(req, "user", &user)
+	}, sessionCookieName+"="+resealed)
+	if user != "bob" {
+		t.Errorf("resent cookie loaded user %q with only the new key, want bob", user)
+	}
+}
@@ -27,18 +27,34 @@
 package session
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+	"unique"
 	"github.com/garyburd/twister/web"
 )
 
 const (
 	sessionCookieName = "twisterSess"
-	sessionValidSeconds = 1440
-	sessionSweepSeconds = 600 * 1000000000
+	sessionValidDuration = 1440 * time.Second
+	sessionSweepInterval = 600 * time.Second
+	//flash messages are kept in the session data under this prefix
+	flashPrefix = "_flash."
+	//with Bind, the session's fingerprint is kept in the session data under this key
+	fingerprintKey = "_fingerprint"
 )
 
 
@@ -46,151 +62,1777 @@ const (
 type sessionHandler struct {
 	h web.Handler
 	manager SessionManager
+	config Config
+	//the sessions each user is logged in to, when MaxSessionsPerUser is set
+	users *userSessions
+}
+
+//options for the session handler, the zero value gives the defaults
+type Config struct {
+	//name of the session cookie, defaults to twisterSess
+	CookieName string
+	//names the session cookie had before, checked in order when the client doesn't
+	//send CookieName, a session found under one is moved to CookieName
+	LegacyCookieNames []string
+	//attributes of the session cookie, nil gives DefaultCookieOptions
+	Cookie *CookieOptions
+	//put HostPrefix or SecurePrefix on the session cookie's name, the handler sets the
+	//attributes browsers require of a prefixed cookie, with HostPrefix it panics if
+	//Cookie has a Domain, or a Path other than /
+	//sessions under the unprefixed name are lost unless it's in LegacyCookieNames
+	CookiePrefix CookiePrefix
+	//when set the cookie value is signed with HMAC-SHA256 using this key,
+	//and cookies with a bad signature are treated as having no session
+	SigningKey []byte
+	//keys SigningKey has replaced, cookies signed with them are still accepted,
+	//and re-signed with SigningKey, so a key can be rotated without logging anyone out
+	OldSigningKeys [][]byte
+	//refuse requests that don't bring a valid existing session, rather than
+	//starting a new empty one for them
+	RequireSession bool
+	//where refused requests are redirected, they get a 401 when it's empty
+	LoginURL string
+	//load sessions without extending their expiry in stores using Sliding, for
+	//polling or asset routes that shouldn't keep an idle session alive
+	NoTouch bool
+	//every request's session is read only, see MarkReadOnly
+	ReadOnly bool
+	//the most sessions a user can be logged in to with Login at once, 0 for no limit
+	//the sessions are tracked by this handler, so servers sharing a store each
+	//apply the limit separately, a session the store destroys or sweeps is
+	//forgotten then, one expired by the backend itself (a redis TTL, say) when
+	//its user next logs in
+	MaxSessionsPerUser int
+	//what Login does when the user is at the limit, the default is EvictOldest
+	SessionLimitPolicy SessionLimitPolicy
+	//accept the session id as an "Authorization: Bearer <id>" header from clients
+	//that send no session cookie, such as API clients that can't keep cookies
+	//those requests get the id back in the SessionHeader response header rather
+	//than a cookie, see UseBearer
+	Bearer bool
+	//load the session from the store when something first asks for it, rather than
+	//for every request, so requests that don't use their session cost the store
+	//nothing
+	//a store using FailClosed gives those requests no session, rather than a 503
+	//RequireSession needs the session up front, so it turns this off
+	LazyLoad bool
+}
+
+//the response header carrying the session id to bearer clients, it's signed like the
+//cookie when there's a SigningKey, and empty when the session has been destroyed
+const SessionHeader = "X-Session-Id"
+
+//attributes applied to the session cookie
+type CookieOptions struct {
+	Path     string
+	Domain   string
+	Secure   bool
+	//set Secure on the cookies sent to requests that came in over https, and leave
+	//it off over plain http, so development servers work without TLS
+	//behind a proxy terminating TLS the request looks like http, so set Secure instead
+	AutoSecure bool
+	HttpOnly   bool
+	//defaults to SameSiteLax, SameSiteNone turns on Secure as browsers require it
+	SameSite SameSite
+}
+
+//a cookie name prefix browsers give extra protection to, see Config.CookiePrefix
+type CookiePrefix string
+
+const (
+	//the cookie is Secure, for Path /, and has no Domain, so it's only ever sent to
+	//the host that set it, and a subdomain can't overwrite it
+	HostPrefix CookiePrefix = "__Host-"
+	//the cookie is Secure, so it can't be set over plain http
+	SecurePrefix CookiePrefix = "__Secure-"
+)
+
+//the SameSite policy of the session cookie
+type SameSite string
+
+const (
+	SameSiteLax    SameSite = "Lax"
+	SameSiteStrict SameSite = "Strict"
+	SameSiteNone   SameSite = "None"
+)
+
+//the cookie attributes used when none are configured, HttpOnly is on
+//so the session id can't be read by scripts
+func DefaultCookieOptions() *CookieOptions {
+	return &CookieOptions{Path: "/", HttpOnly: true, SameSite: SameSiteLax}
 }
 
 //ctor for the sessionhandler, we take a handler and a sessionManager as input params, 
 //and return the session handler
 func SessionHandler(manager SessionManager, h web.Handler) web.Handler {
-	return &sessionHandler{h: h, manager: manager}
+	return NewSessionHandler(manager, h, Config{})
+}
+
+//ctor for a sessionhandler that answers requests without an existing session with a 401,
+//use NewSessionHandler with a LoginURL to redirect them instead
+func RequireSession(manager SessionManager, h web.Handler) web.Handler {
+	return NewSessionHandler(manager, h, Config{RequireSession: true})
+}
+
+//ctor for a sessionhandler with non default options
+//panics on a config that can't work, see CookiePrefix
+func NewSessionHandler(manager SessionManager, h web.Handler, config Config) web.Handler {
+	if config.CookieName == "" {
+		config.CookieName = sessionCookieName
+	}
+	if config.Cookie == nil {
+		config.Cookie = DefaultCookieOptions()
+	} else {
+		//take a copy, so the caller's options aren't changed under us
+		o := *config.Cookie
+		config.Cookie = &o
+	}
+	if config.Cookie.Path == "" {
+		config.Cookie.Path = "/"
+	}
+	if config.Cookie.SameSite == "" {
+		config.Cookie.SameSite = SameSiteLax
+	}
+	if config.Cookie.SameSite == SameSiteNone {
+		config.Cookie.Secure = true
+	}
+	if err := applyCookiePrefix(&config); err != nil {
+		panic(err)
+	}
+	sh := &sessionHandler{h: h, manager: manager, config: config}
+	if config.MaxSessionsPerUser > 0 {
+		sh.users = newUserSessions()
+		//sessions the store sweeps out are forgotten along with those destroyed here
+		if w, ok := manager.(destroyWatcher); ok {
+			w.watchDestroy(sh.users.remove)
+		}
+	}
+	return sh
+}
+
+//prefix the cookie name and set the attributes the prefix requires, the config's
+//Cookie must already be the handler's own copy
+func applyCookiePrefix(config *Config) error {
+	switch config.CookiePrefix {
+	case "":
+		return nil
+	case HostPrefix:
+		if config.Cookie.Domain != "" {
+			return errors.New("session: a __Host- cookie can't have a Domain")
+		}
+		if config.Cookie.Path != "/" {
+			return errors.New("session: a __Host- cookie must have the Path /")
+		}
+	case SecurePrefix:
+	default:
+		return fmt.Errorf("session: unknown cookie prefix %q", config.CookiePrefix)
+	}
+	config.Cookie.Secure = true
+	if !strings.HasPrefix(config.CookieName, string(config.CookiePrefix)) {
+		config.CookieName = string(config.CookiePrefix) + config.CookieName
+	}
+	return nil
+}
+
+//builds the Set-Cookie header value for the session cookie
+//a negative maxAge expires the cookie, 0 leaves it as a browser session cookie
+func (h *sessionHandler) cookie(req *web.Request, value string, maxAge int) string {
+	return h.namedCookie(req, h.config.CookieName, value, maxAge)
+}
+
+//as cookie, for the cookie called name
+func (h *sessionHandler) namedCookie(req *web.Request, name, value string, maxAge int) string {
+	o := h.config.Cookie
+	if value != "" && len(h.config.SigningKey) > 0 {
+		value = h.sign(value)
+	}
+	c := web.NewCookie(name, value).
+		Path(o.Path).
+		Domain(o.Domain).
+		Secure(o.Secure || (o.AutoSecure && secureRequest(req))).
+		HTTPOnly(o.HttpOnly)
+	if maxAge != 0 {
+		c.MaxAge(maxAge)
+	}
+	//twister doesn't know about SameSite, so it's tacked on the end
+	return c.String() + "; SameSite=" + string(o.SameSite)
+}
+
+//whether the request came in over https
+func secureRequest(req *web.Request) bool {
+	return req != nil && req.URL != nil && req.URL.Scheme == "https"
+}
+
+//the handler serving this request, if it went through one
+func handlerFor(req *web.Request) (*sessionHandler, bool) {
+	if req == nil {
+		return nil, false
+	}
+	h, ok := req.Env["sessionHandler"].(*sessionHandler)
+	return h, ok
+}
+
+//whether the request came through a handler configured with NoTouch
+func noTouch(req *web.Request) bool {
+	h, ok := handlerFor(req)
+	return ok && h.config.NoTouch
+}
+
+//the session id the client sent, stores use this rather than reading
+//the cookie themselves so they honour the handler's cookie name and signing
+func requestID(req *web.Request) string {
+	h, ok := handlerFor(req)
+	if !ok {
+		return req.Cookie.Get(sessionCookieName)
+	}
+
+	id, _, _ := h.clientID(req)
+	return id
+}
+
+//the session id the client sent, the name of the cookie it came in, and whether
+//it was signed with one of the OldSigningKeys
+//the legacy names are only tried when there's no cookie under the current name
+//with Bearer, a bearer token is used when there's no cookie, the name is "" then
+func (h *sessionHandler) clientID(req *web.Request) (string, string, bool) {
+	name, val := h.cookieValue(req)
+	if val == "" && h.config.Bearer {
+		name, val = "", bearerToken(req)
+	}
+	if val == "" {
+		return "", "", false
+	}
+	if len(h.config.SigningKey) > 0 {
+		id, key := h.verify(val)
+		if key < 0 {
+			return "", "", false
+		}
+		return id, name, key > 0
+	}
+	return val, name, false
 }
 
 // the mandatory serveWeb method
 func (h *sessionHandler) ServeWeb(req *web.Request) {
-	sess := h.manager.Load(req)
-	req.Env["session"] = sess
+	req.Env["sessionHandler"] = h
+	if h.config.Bearer {
+		if _, val := h.cookieValue(req); val == "" && bearerToken(req) != "" {
+			UseBearer(req)
+		}
+	}
+	var sess *Session
+	if !h.config.LazyLoad || h.config.RequireSession {
+		sess = h.load(req)
+		if err, ok := req.Env["sessionError"].(error); ok {
+			//the store couldn't load the session, and is configured with FailClosed
+			req.Error(web.StatusServiceUnavailable, err)
+			return
+		}
+	}
+	if h.config.ReadOnly {
+		MarkReadOnly(req)
+	}
+	if h.config.RequireSession && (sess == nil || sess.id == "") {
+		//Load found nothing for the client's cookie
+		if h.config.LoginURL != "" {
+			req.Redirect(h.config.LoginURL, false)
+		} else {
+			req.Error(web.StatusUnauthorized, errors.New("session required"))
+		}
+		return
+	}
 
 	web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+		//a session found under a legacy cookie name moves to the current name,
+		//so the old cookie is dropped once the session's safely stored
+		sent, from, oldKey := h.clientID(req)
+		legacy := from != "" && from != h.config.CookieName
+
+		//a session without an id was never written to, so there's nothing to save,
+		//and a read only one mustn't be saved, nor one standing in for a session the
+		//store couldn't load, or the client's cookie would be replaced
+		//with LazyLoad a session nothing asked for isn't loaded now
 		sess, ok := req.Env["session"].(*Session)
-		if !ok {
+		var id string
+		var dirty, sendCookie bool
+		if ok {
+			//other requests may be using the same session
+			sess.mu.Lock()
+			id, dirty, sendCookie = sess.id, sess.dirty, sess.sendCookie
+			sess.sendCookie = false
+			sess.mu.Unlock()
+		}
+		if !ok || id == "" || readOnly(req) || sess.ephemeral {
+			if _, destroyed := req.Env["sessionDestroyed"]; destroyed {
+				//tell the client to drop the cookie or token
+				h.sendID(req, header, "", -1)
+			}
+			if legacy && !readOnly(req) && (!ok || !sess.ephemeral) {
+				header.Add(web.HeaderSetCookie, h.namedCookie(req, from, "", -1))
+			}
 			return status, header
 		}
-		h.manager.Save(req, sess)
-		
-		c := web.NewCookie(sessionCookieName, sess.id).String()
-		header.Add(web.HeaderSetCookie, c)
+		//a session saved by Commit is clean again, but still needs its cookie
+		_, saved := req.Env["sessionSaved"]
+		if dirty {
+			if err := h.save(req, sess); err != nil {
+				//the client doesn't get a cookie for a session that wasn't stored
+				log.Printf("session: could not save session %s: %v", id, err)
+				return status, header
+			}
+			saved = true
+			//saving can rotate the id
+			sess.mu.RLock()
+			id = sess.id
+			sess.mu.RUnlock()
+		}
+
+		if enc, ok := h.manager.(cookieEncoder); ok {
+			//the session lives in the cookie, so it's resent whenever it's saved
+			if saved || legacy || oldKey {
+				if v, err := enc.encodeCookie(sess); err == nil {
+					h.sendID(req, header, v, h.maxAge(sess))
+				}
+			}
+		} else if maxAge := h.maxAge(sess); sent != id || legacy || oldKey || sendCookie ||
+			saved && maxAge != 0 {
+			//the client only needs a cookie when it doesn't already hold this id under
+			//the current name, the cookie's attributes have changed, or a save has
+			//pushed the session's expiry past the cookie's
+			h.sendID(req, header, id, maxAge)
+		}
+		if legacy {
+			header.Add(web.HeaderSetCookie, h.namedCookie(req, from, "", -1))
+		}
 		return status, header
 	})
 	h.h.ServeWeb(req)
 }
 
+//the session cookie the client sent and its name, the legacy names are only
+//tried when there's no cookie under the current name
+func (h *sessionHandler) cookieValue(req *web.Request) (string, string) {
+	name := h.config.CookieName
+	val := req.Cookie.Get(name)
+	for _, legacy := range h.config.LegacyCookieNames {
+		if val != "" {
+			break
+		}
+		name, val = legacy, req.Cookie.Get(legacy)
+	}
+	return name, val
+}
+
+//the token from an "Authorization: Bearer <token>" header, "" if there isn't one
+func bearerToken(req *web.Request) string {
+	auth := req.Header.Get(web.HeaderAuthorization)
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+//send the session's id back in the SessionHeader rather than a cookie, for a login
+//endpoint whose client can't keep cookies say
+//requests that brought a bearer token to a handler with Bearer are marked already
+func UseBearer(req *web.Request) {
+	if req != nil {
+		req.Env["sessionBearer"] = true
+	}
+}
+
+//whether the session id goes back to the client in the SessionHeader
+func bearer(req *web.Request) bool {
+	_, ok := req.Env["sessionBearer"]
+	return ok
+}
+
+//give the client the session's id, or for a store keeping the session in its
+//cookie the whole session, as a cookie or in the SessionHeader for bearer clients
+func (h *sessionHandler) sendID(req *web.Request, header web.Header, value string, maxAge int) {
+	if !bearer(req) {
+		header.Add(web.HeaderSetCookie, h.cookie(req, value, maxAge))
+		return
+	}
+	if value != "" && len(h.config.SigningKey) > 0 {
+		value = h.sign(value)
+	}
+	header.Set(SessionHeader, value)
+}
+
+//load the request's session from the store into the request, it's only ever loaded once
+func (h *sessionHandler) load(req *web.Request) *Session {
+	req.Env["sessionLoaded"] = true
+	sess := h.manager.Load(req)
+	if sess != nil {
+		req.Env["session"] = sess
+		ctx := NewContext(context.Background(), sess)
+		if readOnly(req) {
+			ctx = context.WithValue(ctx, readOnlyKey{}, true)
+		}
+		req.Env["sessionContext"] = ctx
+	}
+	return sess
+}
+
+//value|signature, the signature is the base64 HMAC-SHA256 of value
+func (h *sessionHandler) sign(value string) string {
+	return value + "|" + base64.RawURLEncoding.EncodeToString(h.mac(value))
+}
+
+//check a cookie written by sign, returning the value and which key signed it:
+//0 for SigningKey, i for OldSigningKeys[i-1], and -1 if none of them did
+func (h *sessionHandler) verify(signed string) (string, int) {
+	i := strings.LastIndex(signed, "|")
+	if i < 0 {
+		return "", -1
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signed[i+1:])
+	if err != nil {
+		return "", -1
+	}
+	value := signed[:i]
+	if hmac.Equal(sig, h.mac(value)) {
+		return value, 0
+	}
+	for k, key := range h.config.OldSigningKeys {
+		if hmac.Equal(sig, macWith(key, value)) {
+			return value, k + 1
+		}
+	}
+	return "", -1
+}
+
+func (h *sessionHandler) mac(value string) []byte {
+	return macWith(h.config.SigningKey, value)
+}
+
+func macWith(key []byte, value string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(value))
+	return m.Sum(nil)
+}
+
+//the MaxAge of the session cookie in seconds, what's left of the session's lifetime
+//so the browser drops the cookie when the store drops the session
+//-1 for a session that's already expired, so the cookie goes straight away
+func (h *sessionHandler) maxAge(sess *Session) int {
+	left, ok := h.timeLeft(sess)
+	if !ok {
+		return 0
+	}
+	secs := int(left / time.Second)
+	if secs <= 0 {
+		return -1
+	}
+	return secs
+}
+
+//how long until the manager expires sess, by the manager's clock, negative if it
+//already has, false if the manager doesn't say how long its sessions last
+func (h *sessionHandler) timeLeft(sess *Session) (time.Duration, bool) {
+	now := h.now()
+	var at time.Time
+	sess.mu.RLock()
+	if e, ok := h.manager.(expirer); ok {
+		at = e.expiresAt(sess)
+	} else if sess.maxAge > 0 {
+		at = sess.timestamp.Add(sess.maxAge)
+	} else if m, ok := h.manager.(maxAger); ok {
+		at = sess.timestamp.Add(m.MaxAge())
+	}
+	sess.mu.RUnlock()
+	if at.IsZero() {
+		return 0, false
+	}
+	return at.Sub(now), true
+}
+
+//the time by the manager's clock, see WithClock
+func (h *sessionHandler) now() time.Time {
+	if c, ok := h.manager.(clocked); ok {
+		return c.now()
+	}
+	return time.Now()
+}
+
+//the time by the clock of the store behind the request's handler
+func storeNow(req *web.Request) time.Time {
+	if h, ok := handlerFor(req); ok {
+		return h.now()
+	}
+	return time.Now()
+}
+
+//how long the request's session has left before it expires, taking in SetMaxAge and
+//the store's AbsoluteMaxAge, for telling the user when they'll be logged out
+//with Sliding each request pushes this back out to the full MaxAge
+//false when there's no session, or the store's sessions don't expire
+func TimeToExpiry(req *web.Request) (time.Duration, bool) {
+	sess, ok := Current(req)
+	if !ok {
+		return 0, false
+	}
+	h, ok := handlerFor(req)
+	if !ok {
+		return 0, false
+	}
+	left, ok := h.timeLeft(sess)
+	if left < 0 {
+		left = 0
+	}
+	return left, ok
+}
+
+//persist the session, it's clean again once the manager has it
+//the session is locked while it's saved, so no other request can change it mid write
+//a session due for rotation is saved under a new id, and the old one destroyed
+func (h *sessionHandler) save(req *web.Request, sess *Session) error {
+	sess.mu.Lock()
+	old := sess.rotate()
+	sess.saves++
+	if err := h.manager.Save(req, sess); err != nil {
+		sess.saves--
+		if old != nil {
+			//it keeps its old id, and rotates on the next save instead
+			sess.id, sess.saves = old.id, old.saves
+		}
+		sess.mu.Unlock()
+		return err
+	}
+	sess.dirty = false
+	id := sess.id
+	sess.mu.Unlock()
+
+	if old != nil {
+		h.manager.Destroy(req, old)
+		if h.users != nil {
+			h.users.rename(old.id, id)
+		}
+	}
+	return nil
+}
+
+//give a session that's been saved RotateEvery times a new id, returning a copy
+//under the old id for the store to Destroy, nil if it's not due
+//the session must be write locked
+func (sess *Session) rotate() *Session {
+	if sess.rotateEvery <= 0 || sess.saves < sess.rotateEvery {
+		return nil
+	}
+	id, err := sess.newID()
+	if err != nil {
+		log.Printf("session: could not rotate session id: %v", err)
+		return nil
+	}
+	old := &Session{id: sess.id, data: sess.data, saves: sess.saves, moved: true}
+	sess.id, sess.saves = id, 0
+	return old
+}
+
 //a session manager defines a type of persistant store
-//required methods are Load, Save, and Sweep 
+//required methods are Load, Save, Destroy and Sweep 
+//Save reports why a session couldn't be persisted, the handler then logs it and
+//doesn't send the session cookie
 type SessionManager interface {
 	Load(req *web.Request) *Session
-	Save(req *web.Request, sess *Session) bool
+	Save(req *web.Request, sess *Session) error
+	Destroy(req *web.Request, sess *Session)
 	Sweep()
 }
 
+//managers that can report how many sessions they hold implement CountableStore
+type CountableStore interface {
+	Count() int
+}
+
+//managers that can fetch a session by its id, rather than from a request's cookie,
+//implement IDLoader, so admin tools can inspect or Destroy any session
+//LoadByID returns false for an unknown or expired id
+type IDLoader interface {
+	LoadByID(id string) (*Session, bool)
+}
+
+//managers that can walk through every live session implement Iterable, ForEach
+//stops when fn returns false
+type Iterable interface {
+	ForEach(fn func(id string, sess *Session) bool)
+}
+
+//returned from inside a database's own iteration to end it early, when a ForEach
+//callback returns false
+var errStopIteration = errors.New("session: stop iteration")
+
+//managers that can tell an id they don't hold from one they couldn't read implement
+//CheckedLoader, RetryingStore uses it to retry only the loads that failed
+//LoadChecked returns ErrNotFound for an unknown or expired id
+type CheckedLoader interface {
+	LoadChecked(id string) (*Session, error)
+}
+
+//returned by LoadChecked when there's no live session under the id
+var ErrNotFound = errors.New("session: no such session")
+
+//managers that can drop every session at once implement AllDestroyer, for logging
+//everyone out after a security incident or a change to what's kept in sessions
+//the OnDestroy hook is called for each session removed
+type AllDestroyer interface {
+	DestroyAll() error
+}
+
+//managers that keep the whole session in the cookie, rather than just its id,
+//implement cookieEncoder so the handler knows what to write in the cookie
+type cookieEncoder interface {
+	encodeCookie(sess *Session) (string, error)
+}
+
 
 //an in-memory session store
-//items are stored in a map on the server
+//items are stored in a map on the server, guarded by mu since Load, Save and Sweep
+//are called from different goroutines
+//expiry orders the stored sessions by when they expire
 type memoryStore struct {
-	store map[string]*Session
+	storeConfig
+	sweeper
+	mu     sync.RWMutex
+	store  map[string]*Session
+	expiry *expiryIndex
 }
 
-func MemoryStore() *memoryStore {
-	ms := &memoryStore{store: make(map[string]*Session)}
+func MemoryStore(opts ...Option) *memoryStore {
+	ms := MemoryStoreNoSweep(opts...)
 	go ms.Sweep()
 	return ms
 }
 
-func (s *memoryStore) Load(req *web.Request) *Session {
-	val := req.Cookie.Get(sessionCookieName)
+//ctor for a memory store without the background sweep, for tests that want no
+//goroutines running behind them
+//expired sessions are never removed, but they still can't be loaded
+func MemoryStoreNoSweep(opts ...Option) *memoryStore {
+	ms := &memoryStore{
+		storeConfig: newStoreConfig(opts),
+		sweeper:     newSweeper(),
+		store:       make(map[string]*Session),
+		expiry:      newExpiryIndex(),
+	}
+	ms.taken = ms.has
+	//values are kept as they are, never encoded, so there's nothing to validate
+	ms.validate = false
+	return ms
+}
+
+//whether a session is stored under id
+func (s *memoryStore) has(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.store[id]
+	return ok
+}
 
-	sess, ok := s.store[val]
+func (s *memoryStore) Load(req *web.Request) *Session {
+	sess, ok := s.LoadByID(s.loadID(req))
 	if !ok {
-		sess = NewSession()
+		return s.fresh(req)
 	}
 	
-	return sess
+	return s.loaded(req, sess)
 }
 
-func (s *memoryStore) Save(req *web.Request, sess *Session) bool {
-	sess.timestamp = time.Seconds()
+//the live session stored under id, see IDLoader
+func (s *memoryStore) LoadByID(id string) (*Session, bool) {
+	s.mu.RLock()
+	sess, ok := s.store[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	//it may not have been swept yet
+	sess.mu.RLock()
+	expired := s.expired(sess)
+	sess.mu.RUnlock()
+	if expired {
+		return nil, false
+	}
+	return sess, true
+}
+
+func (s *memoryStore) Save(req *web.Request, sess *Session) error {
+	s.stamp(sess)
+	//the store keeps sess itself, so later saves of it are stamped
+	sess.restored = false
+	if s.intern {
+		sess.intern()
+	}
+	s.mu.Lock()
+	if other, ok := s.store[sess.id]; ok && other != sess {
+		//never clobber another session that got the same id
+		s.mu.Unlock()
+		return errIDCollision
+	}
 	s.store[sess.id] = sess
-	return true
+	s.expiry.set(sess.id, s.expiresAt(sess))
+	s.mu.Unlock()
+	return nil
+}
+
+//remove the session from the store, it's fine if it was never saved
+func (s *memoryStore) Destroy(req *web.Request, sess *Session) {
+	s.mu.Lock()
+	delete(s.store, sess.id)
+	s.expiry.remove(sess.id)
+	s.mu.Unlock()
+	s.destroyed(sess)
+}
+
+//call fn with each session in the store, stopping if it returns false
+//the store is read locked throughout, so fn mustn't block or use the store's
+//write methods
+func (s *memoryStore) ForEach(fn func(id string, sess *Session) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for id, sess := range s.store {
+		if !fn(id, sess) {
+			return
+		}
+	}
+}
+
+//write every session to w as a line of JSON, for a backup or to move the
+//sessions to another store, see Import
+//values go through encoding/json, so they come back with JSONCodec's types
+func (s *memoryStore) Export(w io.Writer) error {
+	s.mu.RLock()
+	sessions := make([]*Session, 0, len(s.store))
+	for _, sess := range s.store {
+		sessions = append(sessions, sess)
+	}
+	s.mu.RUnlock()
+
+	enc := json.NewEncoder(w)
+	for _, sess := range sessions {
+		sess.mu.RLock()
+		err := enc.Encode(toStored(sess))
+		sess.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//read sessions written by Export into the store, keeping their ids and timestamps
+//sessions that have expired since the export are skipped, and a session already in
+//the store is replaced
+func (s *memoryStore) Import(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var ss storedSession
+		if err := dec.Decode(&ss); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		sess := ss.session()
+		if sess.id == "" || s.expired(sess) {
+			continue
+		}
+		if s.intern {
+			sess.intern()
+		}
+		s.mu.Lock()
+		s.store[sess.id] = sess
+		s.expiry.set(sess.id, s.expiresAt(sess))
+		s.mu.Unlock()
+	}
+}
+
+//save every live session to backend, for a server's shutdown so its sessions
+//outlive it, WarmFrom reads them back in on startup
+//backend is given copies keeping their timestamps, so they expire when they would
+//have here, and the sessions here are left alone
+//every session is tried, the first error is returned
+func (s *memoryStore) FlushTo(backend SessionManager) error {
+	s.mu.RLock()
+	sessions := make([]*Session, 0, len(s.store))
+	for _, sess := range s.store {
+		sessions = append(sessions, sess)
+	}
+	s.mu.RUnlock()
+
+	req := &web.Request{Env: make(map[string]interface{})}
+	var first error
+	for _, sess := range sessions {
+		sess.mu.RLock()
+		var cp *Session
+		if !s.expired(sess) {
+			cp = toStored(sess).session()
+			cp.data = make(map[string]interface{}, len(sess.data))
+			for k, v := range sess.data {
+				cp.data[k] = v
+			}
+			cp.restored = true
+		}
+		sess.mu.RUnlock()
+		if cp == nil {
+			continue
+		}
+		if err := backend.Save(req, cp); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+//load every live session backend holds into the store, after a FlushTo to it when
+//the server last shut down, returns how many were loaded
+//backend has to be Iterable, a session already in the store is replaced
+func (s *memoryStore) WarmFrom(backend SessionManager) (int, error) {
+	it, ok := backend.(Iterable)
+	if !ok {
+		return 0, errors.New("session: can't list the sessions in the backend")
+	}
+	n := 0
+	it.ForEach(func(id string, sess *Session) bool {
+		if s.expired(sess) {
+			return true
+		}
+		if s.intern {
+			sess.intern()
+		}
+		s.mu.Lock()
+		s.store[id] = sess
+		s.expiry.set(id, s.expiresAt(sess))
+		s.mu.Unlock()
+		n++
+		return true
+	})
+	return n, nil
+}
+
+//drop every session, see AllDestroyer
+func (s *memoryStore) DestroyAll() error {
+	s.mu.Lock()
+	old := s.store
+	s.store = make(map[string]*Session)
+	s.expiry = newExpiryIndex()
+	s.mu.Unlock()
+
+	for _, sess := range old {
+		s.destroyed(sess)
+	}
+	return nil
+}
+
+//the number of sessions in the store
+func (s *memoryStore) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.store)
+}
+
+//how many sessions have gone each length of time since they were last saved, for
+//judging whether MaxAge is longer than it needs to be
+//buckets are the ascending boundaries between the age ranges, the result has one
+//more entry than buckets: result[0] counts the sessions younger than buckets[0],
+//result[i] those at least buckets[i-1] old and younger than buckets[i], and the
+//last those at least the last boundary old
+func (s *memoryStore) AgeHistogram(buckets []time.Duration) []int {
+	s.mu.RLock()
+	sessions := make([]*Session, 0, len(s.store))
+	for _, sess := range s.store {
+		sessions = append(sessions, sess)
+	}
+	s.mu.RUnlock()
+
+	counts := make([]int, len(buckets)+1)
+	now := s.now()
+	for _, sess := range sessions {
+		sess.mu.RLock()
+		age := now.Sub(sess.timestamp)
+		sess.mu.RUnlock()
+		i := sort.Search(len(buckets), func(i int) bool { return age < buckets[i] })
+		counts[i]++
+	}
+	return counts
 }
 
 //session stores can accumulate cruft
 //you want to be able to sweep the session store, and remove items that are of no further use.
-//this means deleting sessions that have a timestamp that is more then sessionValidSeconds old.
+//this means deleting sessions that have a timestamp that is more then maxAge old,
+//or the session's own max age if it has one.
+//Sweep runs until the store is closed
 func (s *memoryStore) Sweep() {
+	tick, stopTicker := s.sweepTicker()
+	defer stopTicker()
 	for {
-		beg := time.Nanoseconds()
-
-		i := 0
-		l := len(s.store)
-		for k, sess := range s.store {
-			if sess.timestamp + sessionValidSeconds < time.Seconds() {
-				//this session has expired
-				s.store[k] = nil, false
-				i++
-			}
+		beg := time.Now()
+		l, i := s.sweep(s.now())
+		taken := time.Since(beg)
+
+		if s.stats != nil {
+			s.stats(l, i, taken)
+		} else {
+			log.Printf("session store had %d total sessions, but deleted %d sessions. took %v ms",
+				l,i, int64(taken/time.Millisecond))
 		}
-		taken := time.Nanoseconds() - beg
-		
 
-		log.Printf("session store had %d total sessions, but deleted %d sessions. took %v ms",
-			l,i, taken/1000000)
-		time.Sleep(sessionSweepSeconds)
+		select {
+		case <-s.stop:
+			return
+		case <-tick:
+		}
 	}
 
 }
+
+//how many expired sessions a sweep removes each time it takes the store's lock, so
+//requests don't wait on the whole sweep of a large store
+const sweepBatch = 1000
+
+//a single sweep pass, only the sessions that expired before now are visited
+//returns the number of sessions there were, and the number deleted
+func (s *memoryStore) sweep(now time.Time) (int, int) {
+	s.mu.RLock()
+	l := len(s.store)
+	s.mu.RUnlock()
+
+	n := 0
+	for {
+		expired := s.sweepSome(now)
+		for _, sess := range expired {
+			s.destroyed(sess)
+		}
+		n += len(expired)
+		if len(expired) < sweepBatch {
+			return l, n
+		}
+		//let the requests waiting on the lock in between batches
+		runtime.Gosched()
+	}
+}
+
+//remove up to sweepBatch of the sessions that expired before now, and return them
+func (s *memoryStore) sweepSome(now time.Time) []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired []*Session
+	for len(expired) < sweepBatch {
+		id, ok := s.expiry.popExpired(now)
+		if !ok {
+			break
+		}
+		expired = append(expired, s.store[id])
+		delete(s.store, id)
+	}
+	return expired
+}
 //stores the user data
+//mu guards the session's fields, as parallel requests can share a session
 type Session struct {
+	mu sync.RWMutex
 	data map[string]interface{}
 	id string
-	timestamp int64
+	timestamp time.Time
+	//when the session was created, for the store's AbsoluteMaxAge
+	createdAt time.Time
+	//set when data has changed since the session was last saved
+	dirty bool
+	//overrides the store's max age when non zero
+	maxAge time.Duration
+	//set when the client's cookie needs rewriting even though the id is unchanged
+	sendCookie bool
+	//the store's OnCreate hook, called when the session is given its id
+	onCreate func(*Session)
+	//the store's id generator, nil for the default
+	ids IDGenerator
+	//the store's MaxBytes check, nil when there's no limit
+	fits func(*Session) bool
+	//the store's ValidateValues check, nil when values aren't checked
+	valid func(interface{}) bool
+	//marks the copy of a session passed to Destroy by Regenerate
+	moved bool
+	//the fingerprint of the request that created the session, with Bind
+	fingerprint string
+	//the store's OnGet and OnSet hooks
+	onGet func(id, key string)
+	onSet func(id, key string, value interface{})
+	//how many times the session has been saved under its current id, and the
+	//store's RotateEvery
+	saves       int
+	rotateEvery int
+	//bumped each time a store saves the session, see ErrConflict
+	version int64
+	//set on a session the store created because it had none for the request, see IsNew
+	new bool
+	//set on the stand in for a session the store couldn't load, it's never saved
+	ephemeral bool
+	//set on the copy FlushTo saves, which keeps its timestamp and replaces whatever
+	//the store holds, see storeConfig.stamp
+	restored bool
+	//the handles keeping the session's interned strings in the table, see InternStrings
+	interned []unique.Handle[string]
 }
 
 //ctor, returns an initialized session
-func NewSession() *Session {
-	return &Session{id: uuid(), data: make(map[string]interface{}),timestamp: time.Seconds()}
+func NewSession() (*Session, error) {
+	id, err := uuid()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &Session{id: id, data: make(map[string]interface{}),timestamp: now, createdAt: now, dirty: true}, nil
 }
 
+//ctor, an empty session with the given id, for tests and tools that build sessions
+//outside a request
+func NewSessionWithID(id string) *Session {
+	sess := freshSession()
+	sess.id = id
+	return sess
+}
 
-//get information from the store
-func Get(req *web.Request, key string, ret interface{})  {
+//the session's id, "" for a session that hasn't been written to yet
+func (sess *Session) ID() string {
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	return sess.id
+}
+
+//a copy of the session's data, changes to it don't affect the session
+func (sess *Session) Data() map[string]interface{} {
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	m := make(map[string]interface{}, len(sess.data))
+	for k, v := range sess.data {
+		m[k] = v
+	}
+	return m
+}
+
+//used by the stores when there is no existing session to load
+//the session has no id until something is written to it, so requests that
+//never use their session don't fill the store or get a cookie
+func freshSession() *Session {
+	now := time.Now()
+	return &Session{data: make(map[string]interface{}), timestamp: now, createdAt: now}
+}
+
+//flag the session as changed, a session from freshSession is given its id here
+//returns whether the session was just created, and false if an id couldn't be generated
+//the session must be write locked
+func (sess *Session) modified() (bool, bool) {
+	created := false
+	if sess.id == "" {
+		id, err := sess.newID()
+		if err != nil {
+			log.Printf("session: could not create a new session: %v", err)
+			return false, false
+		}
+		sess.id = id
+		created = true
+		if sess.fingerprint != "" {
+			sess.data[fingerprintKey] = sess.fingerprint
+		}
+	}
+	sess.dirty = true
+	return created, true
+}
+
+//run fn with the session write locked, fn returns false if it made no change
+//returns false if the session wasn't changed, or the change took it over the store's MaxBytes
+//the OnCreate hook runs after the lock is released, so it's free to use the session
+func (sess *Session) update(fn func() bool) bool {
+	sess.mu.Lock()
+	var prev map[string]interface{}
+	if sess.fits != nil {
+		//fn only ever replaces values, so a shallow copy is enough to undo it
+		prev = make(map[string]interface{}, len(sess.data))
+		for k, v := range sess.data {
+			prev[k] = v
+		}
+	}
+	ok := fn()
+	if ok && sess.fits != nil && !sess.fits(sess) {
+		sess.data = prev
+		ok = false
+	}
+	created := false
+	if ok {
+		created, ok = sess.modified()
+	}
+	sess.mu.Unlock()
+
+	if created && sess.onCreate != nil {
+		sess.onCreate(sess)
+	}
+	return ok
+}
+
+//when set, the accessors panic for a request that didn't go through a session
+//handler, rather than quietly doing nothing, so a route missing the session
+//middleware is obvious in development, it's off by default
+var StrictMiddleware = false
+
+//the request's session, false if the request didn't go through the session handler
+//every accessor looks the session up here, so they all give their zero result
+//for a nil request or one without a session
+//with LazyLoad the session is loaded here the first time it's asked for
+func Current(req *web.Request) (*Session, bool) {
+	if req == nil {
+		return nil, false
+	}
+	if _, ok := handlerFor(req); !ok && StrictMiddleware {
+		panic("session: session middleware not installed, the request didn't go through a session handler")
+	}
 	sess, ok := req.Env["session"].(*Session)
+	if _, loaded := req.Env["sessionLoaded"]; !ok && !loaded {
+		if h, ok := handlerFor(req); ok && h.config.LazyLoad {
+			sess = h.load(req)
+		}
+	}
+	return sess, sess != nil
+}
+
+//the current session's id, for logging and tracing, "" when there's no session
+//or it hasn't been written to yet
+//without a SigningKey the id is all an attacker needs to take over the session,
+//so keep logs holding it private
+func ID(req *web.Request) string {
+	sess, ok := Current(req)
 	if !ok {
-		return
+		return ""
 	}
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	return sess.id
+}
 
-	val, ok := sess.data[key]
+//whether the request's session was created for this request, because the client
+//sent no cookie or one for a session the store no longer has
+//a first visit, say, false when there's no session
+func IsNew(req *web.Request) bool {
+	sess, ok := Current(req)
 	if !ok {
+		return false
+	}
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	return sess.new
+}
+
+//the request's session if it can be changed, false if it's read only
+//every accessor that changes the session looks it up here
+func writable(req *web.Request) (*Session, bool) {
+	if readOnly(req) {
+		return nil, false
+	}
+	return Current(req)
+}
+
+//whether MarkReadOnly has been called for the request
+func readOnly(req *web.Request) bool {
+	if req == nil {
+		return false
+	}
+	ro, _ := req.Env["sessionReadOnly"].(bool)
+	return ro
+}
+
+//make the request's session read only, for code that should be able to see the
+//session but never change it
+//from then on the accessors that would change the session return false, and the
+//session isn't saved or sent at the end of the request
+//only this request is affected, other requests for the same session can still write to it
+func MarkReadOnly(req *web.Request) {
+	if _, ok := Current(req); !ok {
 		return
 	}
-	
+	req.Env["sessionReadOnly"] = true
+	req.Env["sessionContext"] = context.WithValue(Context(req), readOnlyKey{}, true)
+}
+
+//get information from the store
+func Get(req *web.Request, key string, ret interface{})  {
+	GetOK(req, key, ret)
+}
+
+//get information from the store, returns true only if the key was found and
+//its value could be assigned to ret, which must be a pointer
+func GetOK(req *web.Request, key string, ret interface{}) bool {
+	val, ok := value(req, key)
+	if !ok {
+		return false
+	}
+	return assign(ret, val)
+}
+
+//the value stored under key, or def when the key is missing or holds a value
+//of a different type to def, a nil def accepts any stored value
+func GetDefault(req *web.Request, key string, def interface{}) interface{} {
+	val, ok := value(req, key)
+	if !ok {
+		return def
+	}
+	if def != nil && (val == nil || reflect.TypeOf(val) != reflect.TypeOf(def)) {
+		return def
+	}
+	return val
+}
+
+//copy val into the variable ret points to, if the types allow it
+func assign(ret interface{}, val interface{}) bool {
 	rv := reflect.ValueOf(ret)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || !rv.Elem().CanSet() {
+		return false
+	}
+
+	vv := reflect.ValueOf(val)
+	if !vv.IsValid() || !vv.Type().AssignableTo(rv.Elem().Type()) {
+		return false
+	}
+	rv.Elem().Set(vv)
+	return true
+}
+
+//the raw value stored under key
+func value(req *web.Request, key string) (interface{}, bool) {
+	sess, ok := Current(req)
+	if !ok {
+		return nil, false
+	}
+
+	return sess.get(key)
+}
 
-	if rv.Elem().CanSet() {
-		rv.Elem().Set(reflect.ValueOf(val))
+//read key, telling the OnGet hook
+func (sess *Session) get(key string) (interface{}, bool) {
+	sess.mu.RLock()
+	val, ok := sess.data[key]
+	id, onGet := sess.id, sess.onGet
+	sess.mu.RUnlock()
+	if onGet != nil {
+		onGet(id, key)
 	}
+	return val, ok
+}
+
+//typed getters, these return the zero value and false when the key is
+//missing or holds a value of a different type
+func GetString(req *web.Request, key string) (string, bool) {
+	val, _ := value(req, key)
+	v, ok := val.(string)
+	return v, ok
+}
+
+func GetInt(req *web.Request, key string) (int, bool) {
+	val, _ := value(req, key)
+	v, ok := val.(int)
+	return v, ok
+}
+
+func GetInt64(req *web.Request, key string) (int64, bool) {
+	val, _ := value(req, key)
+	v, ok := val.(int64)
+	return v, ok
+}
+
+func GetBool(req *web.Request, key string) (bool, bool) {
+	val, _ := value(req, key)
+	v, ok := val.(bool)
+	return v, ok
+}
+
+func GetFloat64(req *web.Request, key string) (float64, bool) {
+	val, _ := value(req, key)
+	v, ok := val.(float64)
+	return v, ok
 }
 // set a key, value into the session
 func Set(req *web.Request, key string, value interface{}) bool {
-	sess, ok := req.Env["session"].(*Session)
+	sess, ok := writable(req)
 	if !ok {
 		return false
 	}
+	return sess.set(key, value)
+}
+
+func (sess *Session) set(key string, value interface{}) bool {
+	if !sess.encodable(value) {
+		return false
+	}
+	ok := sess.update(func() bool {
+		sess.data[key] = value
+		return true
+	})
+	if ok {
+		sess.setHook(key, value)
+	}
+	return ok
+}
+
+//whether the store's codec can encode value, always true unless the store uses ValidateValues
+//the session mustn't be locked
+func (sess *Session) encodable(value interface{}) bool {
+	sess.mu.RLock()
+	valid := sess.valid
+	sess.mu.RUnlock()
+	if valid == nil || valid(value) {
+		return true
+	}
+	log.Printf("session: refusing to set a %T, the store can't encode it", value)
+	return false
+}
+
+//tell the OnSet hook a value was set, the session mustn't be locked
+func (sess *Session) setHook(key string, value interface{}) {
+	sess.mu.RLock()
+	id, onSet := sess.id, sess.onSet
+	sess.mu.RUnlock()
+	if onSet != nil {
+		onSet(id, key, value)
+	}
+}
 
-	sess.data[key] = value
+// set all the keys and values in kv together, a concurrent request never sees
+// some of them set and not others
+func SetMany(req *web.Request, kv map[string]interface{}) bool {
+	sess, ok := writable(req)
+	if !ok {
+		return false
+	}
+	for _, v := range kv {
+		if !sess.encodable(v) {
+			return false
+		}
+	}
+	ok = sess.update(func() bool {
+		for k, v := range kv {
+			sess.data[k] = v
+		}
+		return len(kv) > 0
+	})
+	if ok {
+		for k, v := range kv {
+			sess.setHook(k, v)
+		}
+	}
+	return ok
+}
+
+// set key only if the session doesn't have it yet, for defaults that must only be
+// set once, returns false and leaves the value alone if the key is already there
+// the check and the set happen under one lock, so of several requests racing to
+// set the key exactly one wins
+func SetIfAbsent(req *web.Request, key string, value interface{}) bool {
+	sess, ok := writable(req)
+	if !ok || !sess.encodable(value) {
+		return false
+	}
+	ok = sess.update(func() bool {
+		if _, exists := sess.data[key]; exists {
+			return false
+		}
+		sess.data[key] = value
+		return true
+	})
+	if ok {
+		sess.setHook(key, value)
+	}
+	return ok
+}
+
+// add delta to the int stored under key, a missing key counts from zero
+// returns the new value, or false if there's no session or key holds something other than an int
+func Increment(req *web.Request, key string, delta int) (int, bool) {
+	sess, ok := writable(req)
+	if !ok {
+		return 0, false
+	}
+
+	n := 0
+	ok = sess.update(func() bool {
+		if val, ok := sess.data[key]; ok {
+			if n, ok = val.(int); !ok {
+				return false
+			}
+		}
+		n += delta
+		sess.data[key] = n
+		return true
+	})
+	if !ok {
+		return 0, false
+	}
+	sess.setHook(key, n)
+	return n, true
+}
+
+// report whether key is set in the session
+func Has(req *web.Request, key string) bool {
+	_, ok := value(req, key)
+	return ok
+}
+
+// list the keys set in the session, in no particular order
+// returns nil when there's no session
+func Keys(req *web.Request) []string {
+	sess, ok := Current(req)
+	if !ok {
+		return nil
+	}
+
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	keys := make([]string, 0, len(sess.data))
+	for k := range sess.data {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// a copy of the session's data, changes to it don't affect the session
+// returns nil when there's no session
+func Snapshot(req *web.Request) map[string]interface{} {
+	sess, ok := Current(req)
+	if !ok {
+		return nil
+	}
+	return sess.Data()
+}
+
+// a copy of the values the application has set, without the keys the package keeps
+// for itself (flash messages, the CSRF token and so on), for passing to templates
+// returns nil when there's no session
+func Values(req *web.Request) map[string]interface{} {
+	sess, ok := Current(req)
+	if !ok {
+		return nil
+	}
+
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	m := make(map[string]interface{}, len(sess.data))
+	for k, v := range sess.data {
+		if !reserved(k) {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+//whether the package keeps its own data under key, namespaced values belong
+//to their Scope and count as reserved too
+func reserved(key string) bool {
+	return strings.HasPrefix(key, flashPrefix) || strings.HasPrefix(key, namespacePrefix) ||
+		key == csrfKey || key == fingerprintKey || key == userKey
+}
+
+// remove a key from the session, returns false if the key wasn't there
+func Delete(req *web.Request, key string) bool {
+	sess, ok := writable(req)
+	if !ok {
+		return false
+	}
+
+	return sess.update(func() bool {
+		if _, ok := sess.data[key]; !ok {
+			return false
+		}
+		delete(sess.data, key)
+		return true
+	})
+}
+
+// wipe all data from the session, the session id is kept
+func Clear(req *web.Request) bool {
+	sess, ok := writable(req)
+	if !ok {
+		return false
+	}
+
+	now := storeNow(req)
+	return sess.update(func() bool {
+		kept := make(map[string]interface{})
+		//the session stays bound to its client, see Bind, and forms already
+		//rendered with its csrf token still validate
+		for _, k := range []string{fingerprintKey, csrfKey} {
+			if v, ok := sess.data[k]; ok {
+				kept[k] = v
+			}
+		}
+		sess.data = kept
+		sess.timestamp = now
+		return true
+	})
+}
+
+// keep the session alive without changing it, it's saved again with a new timestamp
+// returns false if there's no session, or it has never been saved
+func Touch(req *web.Request) bool {
+	sess, ok := writable(req)
+	if !ok {
+		return false
+	}
+
+	now := storeNow(req)
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.id == "" {
+		return false
+	}
+	sess.timestamp = now
+	sess.dirty = true
 	return true
 }
 
-// generate a (hopefully) unique session id
-func uuid() string {
-	f, err := os.Open("/dev/urandom") 
-	defer f.Close()
-	if err != nil {
+// give the session its own lifetime, in place of the store's max age
+// for example a longer one for a "remember me" login
+func SetMaxAge(req *web.Request, d time.Duration) bool {
+	sess, ok := writable(req)
+	if !ok {
+		return false
+	}
+
+	return sess.update(func() bool {
+		sess.maxAge = d
+		sess.sendCookie = true
+		return true
+	})
+}
+
+// set a one shot message, it's removed from the session the first time it's read
+func SetFlash(req *web.Request, key string, value interface{}) bool {
+	return Set(req, flashPrefix+key, value)
+}
+
+// read a flash message set with SetFlash, and remove it
+// a read only session keeps its flash messages, see MarkReadOnly
+func GetFlash(req *web.Request, key string) (interface{}, bool) {
+	sess, ok := Current(req)
+	if !ok {
+		return nil, false
+	}
+	if readOnly(req) {
+		return value(req, flashPrefix+key)
+	}
+
+	var val interface{}
+	ok = sess.update(func() bool {
+		var ok bool
+		if val, ok = sess.data[flashPrefix+key]; ok {
+			delete(sess.data, flashPrefix+key)
+		}
+		return ok
+	})
+	return val, ok
+}
+
+// read the value under key into ret and remove it in one step, so a one time
+// token can't be used twice by concurrent requests
+// returns false if the key wasn't there, or its value can't be assigned to ret,
+// in which case the key is left alone
+func Pop(req *web.Request, key string, ret interface{}) bool {
+	sess, ok := writable(req)
+	if !ok {
+		return false
+	}
+
+	return sess.update(func() bool {
+		val, ok := sess.data[key]
+		if !ok || !assign(ret, val) {
+			return false
+		}
+		delete(sess.data, key)
+		return true
+	})
+}
+
+// save the session now, rather than when the response is sent, before a long
+// streaming response or a step that mustn't run until the session's stored say
+// changes made afterwards are saved with the response as usual
+// a session that's never been written to has nothing to save
+func Commit(req *web.Request) error {
+	if readOnly(req) {
+		return errors.New("session: can't commit a read only session")
+	}
+	sess, ok := Current(req)
+	if !ok {
+		return errors.New("session: no session to commit")
+	}
+	h, ok := handlerFor(req)
+	if !ok {
+		return errors.New("session: no session handler")
+	}
+	if sess.ephemeral {
+		return errors.New("session: the session couldn't be loaded from the store, so it can't be saved")
+	}
+	if sess.ID() == "" {
+		return nil
+	}
+	if err := h.save(req, sess); err != nil {
+		return err
+	}
+	req.Env["sessionSaved"] = true
+	return nil
+}
+
+// invalidate the session, removing it from the store and expiring the cookie
+func Destroy(req *web.Request) {
+	sess, ok := writable(req)
+	if !ok {
+		return
+	}
+
+	sess.mu.RLock()
+	//a session created for this request and never saved isn't in the store
+	stored := !sess.new || sess.saves > 0
+	sess.mu.RUnlock()
+	if h, ok := handlerFor(req); ok && stored {
+		h.manager.Destroy(req, sess)
+		if h.users != nil {
+			h.users.remove(sess.id)
+		}
+	}
+	delete(req.Env, "session")
+	req.Env["sessionDestroyed"] = true
+}
+
+// give the session a new id, keeping its data, and return the new id
+// call this after a login so an id fixed by an attacker beforehand is useless
+func Regenerate(req *web.Request) string {
+	sess, ok := writable(req)
+	if !ok {
 		return ""
 	}
 
-	b := make([]byte, 16) 
-	_, err = f.Read(b) 
+	sess.mu.Lock()
+	id, err := sess.newID()
 	if err != nil {
+		sess.mu.Unlock()
+		log.Printf("session: could not regenerate session id: %v", err)
 		return ""
 	}
+	old := &Session{id: sess.id, data: sess.data, moved: true}
+	//the session is saved under its new id, and the cookie updated, with the response
+	sess.id, sess.saves = id, 0
+	sess.dirty = true
+	sess.mu.Unlock()
+
+	if h, ok := handlerFor(req); ok {
+		h.manager.Destroy(req, old)
+		if h.users != nil {
+			h.users.rename(old.id, id)
+		}
+	}
+	req.Env["session"] = sess
+	return id
+}
+
+// copy the current session to a new id, the copy is saved straight away and returned
+// later changes to either session don't affect the other, the request keeps the original
+// a store that keeps the session in its cookie has nowhere to put the copy, so with
+// one of those the copy only lives as long as the caller holds it
+func Clone(req *web.Request) (*Session, error) {
+	sess, ok := Current(req)
+	if !ok {
+		return nil, errors.New("session: no session to clone")
+	}
+	h, ok := handlerFor(req)
+	if !ok {
+		return nil, errors.New("session: no session handler")
+	}
+
+	sess.mu.RLock()
+	id, err := sess.newID()
+	if err != nil {
+		sess.mu.RUnlock()
+		return nil, err
+	}
+	now := h.now()
+	clone := &Session{id: id, data: make(map[string]interface{}, len(sess.data)), timestamp: now, createdAt: now,
+		dirty: true, maxAge: sess.maxAge, onCreate: sess.onCreate, ids: sess.ids, fits: sess.fits, valid: sess.valid,
+		onGet: sess.onGet, onSet: sess.onSet, rotateEvery: sess.rotateEvery}
+	for k, v := range sess.data {
+		clone.data[k] = deepCopy(v)
+	}
+	sess.mu.RUnlock()
+
+	if err := h.save(req, clone); err != nil {
+		return nil, err
+	}
+	if clone.onCreate != nil {
+		clone.onCreate(clone)
+	}
+	return clone, nil
+}
+
+//a copy of v sharing no maps or slices with it, anything else is copied by value
+func deepCopy(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return copyValue(reflect.ValueOf(v)).Interface()
+}
+
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			m.SetMapIndex(k, copyValue(v.MapIndex(k)))
+		}
+		return m
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		s := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			s.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return s
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem()))
+		return c
+	}
+	return v
+}
+
+//generates session ids, see WithIDGenerator
+//ids are sent in the session cookie and used as store keys, so they should stick
+//to letters, digits, '-' and '_'
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+//the default IDGenerator
+type randomIDs struct{}
+
+func (randomIDs) NewID() (string, error) {
+	return uuid()
+}
+
+//a new id from the session's generator
+func (sess *Session) newID() (string, error) {
+	if sess.ids == nil {
+		return uuid()
+	}
+	return sess.ids.NewID()
+}
+
+// generate a (hopefully) unique session id
+func uuid() (string, error) {
+	b := make([]byte, 16) 
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
 
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]) 
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
 }
@@ -0,0 +1,285 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//the flavour of SQL spoken by the database behind a SQLStore
+type SQLDialect int
+
+const (
+	MySQL SQLDialect = iota
+	Postgres
+	SQLite
+)
+
+//a session store keeping one row per session in a database table
+//the table has the columns (id, data, updated_at), see CreateTable
+type SQLStore struct {
+	storeConfig
+	sweeper
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+}
+
+//ctor, table must be a plain identifier since it can't be passed as a query parameter
+func NewSQLStore(db *sql.DB, table string, dialect SQLDialect, opts ...Option) (*SQLStore, error) {
+	if !validIdentifier(table) {
+		return nil, errors.New("session: invalid table name " + table)
+	}
+	s := &SQLStore{storeConfig: newStoreConfig(opts), sweeper: newSweeper(), db: db, dialect: dialect, table: table}
+	go s.Sweep()
+	return s, nil
+}
+
+func validIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+//the n'th (from 1) query parameter
+func (s *SQLStore) param(n int) string {
+	if s.dialect == Postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+//create the session table if it doesn't already exist
+func (s *SQLStore) CreateTable() error {
+	idType, dataType := "TEXT", "BLOB"
+	switch s.dialect {
+	case MySQL:
+		//mysql can't index an unbounded TEXT column
+		idType = "VARCHAR(255)"
+	case Postgres:
+		dataType = "BYTEA"
+	}
+	_, err := s.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id %s PRIMARY KEY, data %s, updated_at TIMESTAMP)",
+		s.table, idType, dataType))
+	return err
+}
+
+//a backend that can't be read is handled as the store's FailureMode says
+func (s *SQLStore) Load(req *web.Request) *Session {
+	return s.loadChecked(req, s)
+}
+
+//the live session stored under id, see IDLoader
+func (s *SQLStore) LoadByID(id string) (*Session, bool) {
+	sess, err := s.LoadChecked(id)
+	if err != nil && err != ErrNotFound {
+		log.Printf("session: sql load of %s failed: %v", id, err)
+	}
+	return sess, err == nil
+}
+
+//LoadByID, with the error when the backend couldn't be read, see CheckedLoader
+func (s *SQLStore) LoadChecked(id string) (*Session, error) {
+	if id == "" {
+		return nil, ErrNotFound
+	}
+
+	var b []byte
+	q := fmt.Sprintf("SELECT data FROM %s WHERE id = %s", s.table, s.param(1))
+	err := s.db.QueryRow(q, id).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decode(id, b)
+}
+
+//the stored version is checked in the same transaction as the write, with the row
+//locked where the database supports it, see ErrConflict
+func (s *SQLStore) Save(req *web.Request, sess *Session) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := fmt.Sprintf("SELECT data FROM %s WHERE id = %s", s.table, s.param(1))
+	if s.dialect != SQLite {
+		//sqlite locks the whole database for the write anyway
+		q += " FOR UPDATE"
+	}
+	var stored []byte
+	var current *Session
+	err = tx.QueryRow(q, sess.id).Scan(&stored)
+	if err == nil {
+		//an undecodable row is overwritten
+		current, _ = s.codec.Unmarshal(stored)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	if err := s.nextVersion(current, sess); err != nil {
+		return err
+	}
+
+	s.stamp(sess)
+	b, err := s.codec.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(s.upsert(), sess.id, b, sess.timestamp.UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//the insert-or-update statement for the dialect, taking id, data and updated_at
+func (s *SQLStore) upsert() string {
+	insert := fmt.Sprintf("INSERT INTO %s (id, data, updated_at) VALUES (%s, %s, %s)",
+		s.table, s.param(1), s.param(2), s.param(3))
+	if s.dialect == MySQL {
+		return insert + " ON DUPLICATE KEY UPDATE data = VALUES(data), updated_at = VALUES(updated_at)"
+	}
+	return insert + " ON CONFLICT (id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at"
+}
+
+func (s *SQLStore) Destroy(req *web.Request, sess *Session) {
+	q := fmt.Sprintf("DELETE FROM %s WHERE id = %s", s.table, s.param(1))
+	if _, err := s.db.Exec(q, sess.id); err != nil {
+		log.Printf("session: sql delete of %s failed: %v", sess.id, err)
+	}
+	s.destroyed(sess)
+}
+
+//delete every row from the session table, see AllDestroyer
+func (s *SQLStore) DestroyAll() error {
+	var sessions []*Session
+	if s.onDestroy != nil {
+		//the hook wants the sessions, so they're read before they go
+		rows, err := s.db.Query(fmt.Sprintf("SELECT data FROM %s", s.table))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var b []byte
+			if err := rows.Scan(&b); err != nil {
+				rows.Close()
+				return err
+			}
+			if sess, err := s.codec.Unmarshal(b); err == nil {
+				sessions = append(sessions, sess)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s", s.table)); err != nil {
+		return err
+	}
+	for _, sess := range sessions {
+		s.destroyed(sess)
+	}
+	return nil
+}
+
+//delete the rows that haven't been updated in maxAge
+//Sweep runs until the store is closed
+func (s *SQLStore) Sweep() {
+	tick, stopTicker := s.sweepTicker()
+	defer stopTicker()
+	for {
+		beg := time.Now()
+
+		l, i, err := s.sweep()
+		if err != nil {
+			log.Printf("session: sql sweep failed: %v", err)
+		} else if taken := time.Since(beg); s.stats != nil {
+			s.stats(l, i, taken)
+		} else {
+			log.Printf("session sql store had %d total sessions, but deleted %d sessions. took %v ms",
+				l, i, int64(taken/time.Millisecond))
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-tick:
+		}
+	}
+}
+
+//a single sweep pass, returns the number of sessions there were and the number deleted
+//rows older than the store's max age are decoded before being deleted, since
+//a session given a longer max age with SetMaxAge may still be live
+func (s *SQLStore) sweep() (int, int, error) {
+	var l int
+	if err := s.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s", s.table)).Scan(&l); err != nil {
+		return 0, 0, err
+	}
+
+	//a row saved again since it was read is newer than the cutoff, and kept
+	cutoff := s.now().Add(-s.maxAge).UTC()
+	q := fmt.Sprintf("SELECT id, data FROM %s WHERE updated_at < %s", s.table, s.param(1))
+	rows, err := s.db.Query(q, cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	var expired []string
+	//the decoded session for each expired id, nil when it couldn't be decoded
+	var sessions []*Session
+	for rows.Next() {
+		var id string
+		var b []byte
+		if err := rows.Scan(&id, &b); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		sess, err := s.codec.Unmarshal(b)
+		if err != nil {
+			expired = append(expired, id)
+			sessions = append(sessions, nil)
+		} else if s.expired(sess) {
+			expired = append(expired, id)
+			sessions = append(sessions, sess)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	del := fmt.Sprintf("DELETE FROM %s WHERE id = %s AND updated_at < %s", s.table, s.param(1), s.param(2))
+	i := 0
+	for n, id := range expired {
+		res, err := s.db.Exec(del, id, cutoff)
+		if err != nil {
+			return l, i, err
+		}
+		//drivers that can't say are taken at their word that it went
+		if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+			continue
+		}
+		i++
+		if sessions[n] != nil {
+			s.destroyed(sessions[n])
+		}
+	}
+	return l, i, nil
+}
@@ -0,0 +1,120 @@
+package session
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//a SQLStore over a fresh in-memory sqlite database
+func newSQLiteStore(t *testing.T, opts ...Option) *SQLStore {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//each connection would get a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	store, err := NewSQLStore(db, "sessions", SQLite, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	if err := store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSQLStore(t *testing.T) {
+	store := newSQLiteStore(t)
+	id := seed(t, store, map[string]interface{}{"user": "bob"})
+
+	var user string
+	serve(store, func(req *web.Request) {
+		Get(req, "user", &user)
+		Set(req, "visits", 1)
+	}, sessionCookieName+"="+id)
+	if user != "bob" {
+		t.Errorf("loaded user %q, want bob", user)
+	}
+	sess, ok := store.LoadByID(id)
+	if !ok {
+		t.Fatal("updated session doesn't load")
+	}
+	if n, _ := sess.data["visits"].(int); n != 1 {
+		t.Errorf("visits = %v after the update, want 1", sess.data["visits"])
+	}
+
+	serve(store, func(req *web.Request) {
+		Destroy(req)
+	}, sessionCookieName+"="+id)
+	if _, ok := store.LoadByID(id); ok {
+		t.Error("destroyed session loaded")
+	}
+	if _, err := store.LoadChecked(id); err != ErrNotFound {
+		t.Errorf("LoadChecked of a destroyed session = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLStoreSweep(t *testing.T) {
+	clock := newFakeClock()
+	store := newSQLiteStore(t, MaxAge(10*time.Minute), WithClock(clock))
+	old := seed(t, store, map[string]interface{}{"a": 1})
+	clock.advance(6 * time.Minute)
+	live := seed(t, store, map[string]interface{}{"a": 1})
+	clock.advance(6 * time.Minute)
+
+	total, deleted, err := store.sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || deleted != 1 {
+		t.Errorf("sweep found %d sessions and deleted %d, want 2 and 1", total, deleted)
+	}
+	if _, ok := store.LoadByID(old); ok {
+		t.Error("expired session loaded")
+	}
+	if _, ok := store.LoadByID(live); !ok {
+		t.Error("live session swept")
+	}
+}
+
+func TestSQLStoreSweepKeepsResaved(t *testing.T) {
+	clock := newFakeClock()
+	var store *SQLStore
+	var destroyed []string
+	ids := make(map[string]bool)
+	store = newSQLiteStore(t, MaxAge(10*time.Minute), WithClock(clock), OnDestroy(func(sess *Session) {
+		destroyed = append(destroyed, sess.id)
+		//the other session is saved again between the sweep reading it and deleting it
+		for id := range ids {
+			if id != sess.id {
+				if _, err := store.db.Exec("UPDATE sessions SET updated_at = ? WHERE id = ?", clock.Now().UTC(), id); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}))
+	a := seed(t, store, map[string]interface{}{"a": 1})
+	b := seed(t, store, map[string]interface{}{"a": 1})
+	ids[a], ids[b] = true, true
+	clock.advance(11 * time.Minute)
+
+	_, deleted, err := store.sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 || len(destroyed) != 1 {
+		t.Fatalf("sweep deleted %d and destroyed %q, want just the one", deleted, destroyed)
+	}
+	var n int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE id <> ?", destroyed[0]).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("the session saved again has %d rows, want 1", n)
+	}
+}
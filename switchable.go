@@ -0,0 +1,164 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+//a SessionManager passing everything on to another manager, which can be swapped
+//while the server is running, to move to a new backend without a restart
+type SwitchableManager struct {
+	mu      sync.RWMutex
+	current SessionManager
+	//the manager before the last Swap, sessions it still holds are loaded
+	//from it and saved to the current one, it's let go once it's empty, when it's
+	//a CountableStore, or once everything has been copied out of it, or at the
+	//next Swap
+	previous SessionManager
+	//passed to watchDestroy, and on to each manager swapped in
+	watchers []func(id string)
+}
+
+//ctor, m handles the sessions until the first Swap
+func NewSwitchableManager(m SessionManager) *SwitchableManager {
+	return &SwitchableManager{current: m}
+}
+
+//route sessions to m from now on, requests already running finish with whichever
+//manager they started on
+//sessions left in the old manager move to m as they're used, unless copyAll is set,
+//in which case they're all saved to m now, this needs the old manager to be Iterable
+//and nothing is swapped when it isn't
+func (s *SwitchableManager) Swap(m SessionManager, copyAll bool) error {
+	s.mu.Lock()
+	old := s.current
+	all, ok := old.(Iterable)
+	if copyAll && !ok {
+		s.mu.Unlock()
+		return errors.New("session: can't list the sessions in the current manager to copy them")
+	}
+	s.previous, s.current = old, m
+	if w, ok := m.(destroyWatcher); ok {
+		for _, fn := range s.watchers {
+			w.watchDestroy(fn)
+		}
+	}
+	s.mu.Unlock()
+
+	if !copyAll {
+		return nil
+	}
+	req := &web.Request{Env: make(map[string]interface{})}
+	var err error
+	all.ForEach(func(id string, sess *Session) bool {
+		sess.mu.Lock()
+		err = m.Save(req, sess)
+		sess.mu.Unlock()
+		return err == nil
+	})
+	if err == nil {
+		s.retire(old)
+	}
+	return err
+}
+
+//stop loading sessions from old, if it's still the previous manager
+func (s *SwitchableManager) retire(old SessionManager) {
+	s.mu.Lock()
+	if s.previous == old {
+		s.previous = nil
+	}
+	s.mu.Unlock()
+}
+
+func (s *SwitchableManager) managers() (SessionManager, SessionManager) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current, s.previous
+}
+
+func (s *SwitchableManager) Load(req *web.Request) *Session {
+	current, previous := s.managers()
+	sess := current.Load(req)
+	if (sess == nil || sess.id == "") && previous != nil && requestID(req) != "" {
+		//not moved yet, it's marked dirty so it's saved to the current manager
+		if old := previous.Load(req); old != nil && old.id != "" {
+			old.mu.Lock()
+			old.dirty = true
+			old.mu.Unlock()
+			req.Env["sessionSwitched"] = true
+			return old
+		}
+	}
+	return sess
+}
+
+//a session loaded from the previous manager is removed from it once it's saved
+//to the current one
+func (s *SwitchableManager) Save(req *web.Request, sess *Session) error {
+	current, previous := s.managers()
+	if err := current.Save(req, sess); err != nil {
+		return err
+	}
+	if _, switched := req.Env["sessionSwitched"]; switched && previous != nil {
+		delete(req.Env, "sessionSwitched")
+		//moved, so it's not reported to OnDestroy, the session lives on
+		previous.Destroy(req, &Session{id: sess.id, data: sess.data, moved: true})
+		if c, ok := previous.(CountableStore); ok && c.Count() == 0 {
+			s.retire(previous)
+		}
+	}
+	return nil
+}
+
+//the session is destroyed in the previous manager too, so it can't come back from there
+func (s *SwitchableManager) Destroy(req *web.Request, sess *Session) {
+	current, previous := s.managers()
+	current.Destroy(req, sess)
+	if previous != nil {
+		previous.Destroy(req, sess)
+	}
+}
+
+//the managers behind it sweep themselves, so there's nothing to do
+func (s *SwitchableManager) Sweep() {}
+
+//the current manager's MaxAge, see maxAger
+func (s *SwitchableManager) MaxAge() time.Duration {
+	current, _ := s.managers()
+	if m, ok := current.(maxAger); ok {
+		return m.MaxAge()
+	}
+	return 0
+}
+
+//when the current manager expires sess, see expirer
+func (s *SwitchableManager) expiresAt(sess *Session) time.Time {
+	current, _ := s.managers()
+	if e, ok := current.(expirer); ok {
+		return e.expiresAt(sess)
+	}
+	return time.Time{}
+}
+
+//watch the current manager's destroyed sessions, and those of the managers
+//swapped in after it, see destroyWatcher
+func (s *SwitchableManager) watchDestroy(fn func(id string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers = append(s.watchers, fn)
+	if w, ok := s.current.(destroyWatcher); ok {
+		w.watchDestroy(fn)
+	}
+}
+
+//the current manager's clock, see clocked
+func (s *SwitchableManager) now() time.Time {
+	current, _ := s.managers()
+	if c, ok := current.(clocked); ok {
+		return c.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"testing"
+	"time"
+	"github.com/garyburd/twister/web"
+)
+
+func TestSwap(t *testing.T) {
+	old := MemoryStoreNoSweep()
+	client := newFakeRedis()
+	next := RedisStoreWithClient(client)
+	manager := NewSwitchableManager(old)
+	moved := seed(t, manager, map[string]interface{}{"user": "bob"})
+
+	if err := manager.Swap(next, false); err != nil {
+		t.Fatal(err)
+	}
+	created := seed(t, manager, map[string]interface{}{"user": "alice"})
+	if _, ok := client.keys[sessionKey(created)]; !ok {
+		t.Error("session created after Swap isn't in the new backend")
+	}
+	if _, ok := old.LoadByID(created); ok {
+		t.Error("session created after Swap went to the old backend")
+	}
+
+	//a session still in the old backend is loaded from it, and saved to the new one
+	var user string
+	serve(manager, func(req *web.Request) {
+		Get(req, "user", &user)
+	}, sessionCookieName+"="+moved)
+	if user != "bob" {
+		t.Errorf("session left in the old backend loaded user %q, want bob", user)
+	}
+	if _, ok := client.keys[sessionKey(moved)]; !ok {
+		t.Error("session from the old backend wasn't moved to the new one")
+	}
+}
+
+func TestSwapCopyAll(t *testing.T) {
+	old := MemoryStoreNoSweep()
+	manager := NewSwitchableManager(old)
+	ids := []string{
+		seed(t, manager, map[string]interface{}{"n": 1}),
+		seed(t, manager, map[string]interface{}{"n": 2}),
+	}
+	client := newFakeRedis()
+	next := RedisStoreWithClient(client)
+	if err := manager.Swap(next, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, ok := client.keys[sessionKey(id)]; !ok {
+			t.Errorf("session %s not copied to the new backend", id)
+		}
+	}
+
+	//redis can't list its sessions, so they can't be copied out of it
+	if err := manager.Swap(MemoryStoreNoSweep(), true); err == nil {
+		t.Error("Swap copying from a manager that isn't Iterable succeeded")
+	}
+	if current, _ := manager.managers(); current != SessionManager(next) {
+		t.Error("failed Swap changed the manager")
+	}
+}
+
+func TestSwapDrains(t *testing.T) {
+	old := MemoryStoreNoSweep()
+	manager := NewSwitchableManager(old)
+	ids := []string{
+		seed(t, manager, map[string]interface{}{"n": 1}),
+		seed(t, manager, map[string]interface{}{"n": 2}),
+	}
+	next := MemoryStoreNoSweep()
+	if err := manager.Swap(next, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, id := range ids {
+		serve(manager, func(req *web.Request) {}, sessionCookieName+"="+id)
+		if _, ok := old.LoadByID(id); ok {
+			t.Errorf("session %s still in the old manager after moving", id)
+		}
+		if _, ok := next.LoadByID(id); !ok {
+			t.Errorf("session %s not moved to the new manager", id)
+		}
+		if _, previous := manager.managers(); (previous == nil) != (i == len(ids)-1) {
+			t.Errorf("previous manager is %v with %d sessions left", previous, old.Count())
+		}
+	}
+
+	//the sessions copied, the old manager is let go straight away
+	if err := manager.Swap(MemoryStoreNoSweep(), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, previous := manager.managers(); previous != nil {
+		t.Error("previous manager kept after copying everything out of it")
+	}
+}
+
+func TestSwitchableSweepReturns(t *testing.T) {
+	store := MemoryStore()
+	defer store.Close()
+	manager := NewSwitchableManager(store)
+	done := make(chan struct{})
+	go func() {
+		manager.Sweep()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Sweep blocked")
+	}
+}
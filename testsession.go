@@ -0,0 +1,26 @@
+package session
+
+import (
+	"github.com/garyburd/twister/web"
+)
+
+//a request with a session holding kv, for testing handlers that use sessions without
+//running a server, the accessors work on it as they would inside the session handler
+//the request goes through a handler over a MemoryStoreNoSweep, so Destroy and
+//Regenerate work too, but nothing is saved as there's no response
+func NewTestSession(kv map[string]interface{}) (*web.Request, *Session) {
+	store := MemoryStoreNoSweep()
+	req := &web.Request{
+		Header: make(web.Header),
+		Param:  make(web.Values),
+		Cookie: make(web.Values),
+		Env:    make(map[string]interface{}),
+	}
+	req.Env["sessionHandler"] = NewSessionHandler(store, nil, Config{})
+	sess := store.fresh(req)
+	req.Env["session"] = sess
+	if len(kv) > 0 {
+		SetMany(req, kv)
+	}
+	return req, sess
+}
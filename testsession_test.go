@@ -0,0 +1,47 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewTestSession(t *testing.T) {
+	req, sess := NewTestSession(map[string]interface{}{"user": "bob", "n": 1})
+	if user, _ := GetString(req, "user"); user != "bob" {
+		t.Errorf("user = %q, want bob", user)
+	}
+	if n, ok := Increment(req, "n", 1); !ok || n != 2 {
+		t.Errorf("Increment = %d, %v, want 2", n, ok)
+	}
+	if !Delete(req, "user") || Has(req, "user") {
+		t.Error("Delete didn't remove user")
+	}
+	if sess.Data()["n"] != 2 {
+		t.Errorf("returned session has n = %v, want 2", sess.Data()["n"])
+	}
+
+	old := ID(req)
+	if id := Regenerate(req); id == "" || id == old {
+		t.Errorf("Regenerate gave %q for %q", id, old)
+	}
+	Destroy(req)
+	if Has(req, "n") {
+		t.Error("session still has values after Destroy")
+	}
+}
+
+func TestMemoryStoreNoSweep(t *testing.T) {
+	clock := newFakeClock()
+	store := MemoryStoreNoSweep(MaxAge(time.Minute), WithClock(clock), SweepInterval(time.Millisecond))
+	seed(t, store, map[string]interface{}{"a": 1})
+	clock.advance(time.Hour)
+
+	//no sweep loop runs, the expired session stays until swept by hand
+	time.Sleep(20 * time.Millisecond)
+	if n := store.Count(); n != 1 {
+		t.Errorf("Count = %d without a sweep, want 1", n)
+	}
+	if _, deleted := store.sweep(clock.Now()); deleted != 1 {
+		t.Errorf("sweep deleted %d sessions, want 1", deleted)
+	}
+}